@@ -1,21 +1,63 @@
 package apconf
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"sync"
+	"text/template"
 )
 
 type Config struct {
+	// mu serializes reloads and updates, e.g. those started by Watch, and
+	// guards the computed state read by the accessors. Validators and
+	// deployers run with it held and must not call the methods of the
+	// Config.
+	mu                  sync.Mutex
 	configRoot          string
 	configBasenames     []string
 	templateParams      map[string]any
+	templateFuncs       template.FuncMap
+	templateMode        TemplateMode
+	strictTemplates     bool
+	valuesFiles         []string
 	configPreprocessors []func(map[string]any)
 	configDeployers     []func(map[string]any, map[string]any, ConfigDiffResult) error
 	configValidators    []func(map[string]any, map[string]any, ConfigDiffResult) bool
+	source              Source
+	filter              fileFilter
+	envPrefix           string
+	setFlags            *SetFlags
+	defaults            map[string]any
+	labelSelector       string
+	selector            Selector
+	layers              []*layer
+	provenance          provenance
+	kinds               []kindPipeline
+	documents           map[string]any
+	decoded             map[string]map[string]any
 	config              map[string]any
 }
 
+// Option customizes how a Config is loaded.
+type Option func(*Config)
+
+// WithFS makes the Config read its profiles from the configRoot
+// directory of fsys, e.g. an embed.FS with default profiles.
+func WithFS(fsys fs.FS) Option {
+	return func(c *Config) {
+		c.source = NewFSSource(fsys, c.configRoot)
+	}
+}
+
+// WithSource makes the Config read its profiles from source instead of
+// the directories under configRoot.
+func WithSource(source Source) Option {
+	return func(c *Config) {
+		c.source = source
+	}
+}
+
 type Exception struct {
 	message string
 }
@@ -24,13 +66,110 @@ func (e *Exception) Error() string {
 	return e.message
 }
 
+// NewConfig is like LoadConfig but panics if the config cannot be loaded.
 func NewConfig(
 	configRoot string,
 	configBasenames []string,
 	templateParams map[string]any,
 	configPreprocessors []func(map[string]any),
 	configDeployers []func(map[string]any, map[string]any, ConfigDiffResult) error,
-	configValidators []func(map[string]any, map[string]any, ConfigDiffResult) bool) *Config {
+	configValidators []func(map[string]any, map[string]any, ConfigDiffResult) bool,
+	opts ...Option) *Config {
+
+	c, err := LoadConfig(
+		configRoot,
+		configBasenames,
+		templateParams,
+		configPreprocessors,
+		configDeployers,
+		configValidators,
+		opts...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// WithRecursive makes the Config also load documents from the
+// subdirectories of each profile, in lexical order of their paths.
+func WithRecursive() Option {
+	return func(c *Config) {
+		c.filter.recursive = true
+	}
+}
+
+// WithInclude restricts the documents loaded from each profile to those
+// matching one of the patterns, e.g. "crawl/*.yaml". Patterns ending with
+// a slash match directories, patterns without a slash match file names.
+func WithInclude(patterns ...string) Option {
+	return func(c *Config) {
+		c.filter.include = append(c.filter.include, patterns...)
+	}
+}
+
+// WithExclude skips the documents matching one of the patterns, e.g.
+// "*.example.yaml" or "_drafts/". See WithInclude for the syntax.
+func WithExclude(patterns ...string) Option {
+	return func(c *Config) {
+		c.filter.exclude = append(c.filter.exclude, patterns...)
+	}
+}
+
+// WithEnvOverlay overrides config values with the environment variables
+// named prefix, two underscores and the upper-cased path of the value
+// joined by two underscores, e.g. APCONF__CRAWLER_CONFIG__SPEC__NUM_WORKERS
+// for prefix APCONF. Values are converted to the type of the value they
+// replace. Variables naming a document no profile defines fail loading.
+// The overlay forms LayerEnv, above the profiles.
+func WithEnvOverlay(prefix string) Option {
+	return func(c *Config) {
+		c.envPrefix = prefix
+	}
+}
+
+// WithSetFlags overrides config values with the --set and --set-file
+// flags registered by RegisterSetFlags. They form LayerFlags, above
+// LayerEnv.
+func WithSetFlags(flags *SetFlags) Option {
+	return func(c *Config) {
+		c.setFlags = flags
+	}
+}
+
+// WithDefaults sets config values below all profiles, e.g.
+// {"crawler_config": {"spec": {"num_workers": 1}}}.
+func WithDefaults(defaults map[string]any) Option {
+	return func(c *Config) {
+		c.defaults = defaults
+	}
+}
+
+// WithLabelSelector loads only the documents whose metadata.labels match
+// selector, e.g. "env=prod,region in (eu,us)". Like in Kubernetes,
+// documents without labels are matched as having none, so they are
+// skipped by selectors requiring a label, e.g. "env=prod", and loaded by
+// those excluding one, e.g. "env!=dev". See Selector for the syntax.
+func WithLabelSelector(selector string) Option {
+	return func(c *Config) {
+		c.labelSelector = selector
+	}
+}
+
+// LoadConfig reads the profile directories configBasenames under configRoot,
+// or the profiles of the source given WithSource, merges their documents
+// and applies the resulting config. Profiles required by the manifests of
+// configBasenames are loaded before them. Their default params and values
+// files, see ManifestName and ValuesName, are overridden by templateParams.
+// Failures are reported as *DirNotFoundError, *ReadError, *TemplateError,
+// *ParseError or *ProfileCycleError.
+func LoadConfig(
+	configRoot string,
+	configBasenames []string,
+	templateParams map[string]any,
+	configPreprocessors []func(map[string]any),
+	configDeployers []func(map[string]any, map[string]any, ConfigDiffResult) error,
+	configValidators []func(map[string]any, map[string]any, ConfigDiffResult) bool,
+	opts ...Option) (*Config, error) {
 
 	c := &Config{
 		configRoot:          configRoot,
@@ -41,24 +180,73 @@ func NewConfig(
 		configValidators:    configValidators,
 		config:              make(map[string]any),
 	}
-	c.init()
-	return c
-}
-
-func (c *Config) init() {
-	configDirs := make([]string, len(c.configBasenames))
-	for i, basename := range c.configBasenames {
-		configDirs[i] = filepath.Join(c.configRoot, basename)
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.source == nil {
+		c.source = NewDirSource(configRoot)
+	}
+	profiles, manifestParams, err := resolveProfiles(c.source, configBasenames)
+	if err != nil {
+		return nil, err
+	}
+	c.configBasenames = profiles
+	params, err := c.loadValuesFiles(manifestParams, profiles)
+	if err != nil {
+		return nil, err
+	}
+	c.templateParams = mergeParams(params, templateParams)
+	selector, err := ParseSelector(c.labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	c.selector = selector
+	c.buildLayers(c.defaults)
+	if err := c.Reload(); err != nil {
+		return nil, err
 	}
+	return c, nil
+}
 
-	for _, dir := range configDirs {
-		if _, err := os.Stat(dir); os.IsNotExist(err) {
-			panic(&Exception{message: fmt.Sprintf("Config directory %s does not exist.", dir)})
+// Reload re-reads the profiles and applies the config computed from
+// all layers. If that fails, the layers keep the documents applied
+// before. It is safe for concurrent use, e.g. with Watch.
+func (c *Config) Reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	profileDocs := make(map[*layer][]document)
+	for _, l := range c.layers {
+		if l.profile == "" {
+			continue
+		}
+		docs, err := c.loadProfile(l.profile)
+		if err != nil {
+			return err
 		}
+		profileDocs[l] = docs
 	}
+	saved := c.saveLayers()
+	for l, docs := range profileDocs {
+		l.kind = documentsLayer
+		l.docs = docs
+		l.values = nil
+	}
+	return c.updateOrRestore(saved)
+}
 
-	config := processYamlDirs(configDirs, c.templateParams)["Config"].(map[string]any)
-	c.apply(config)
+// Watch reloads the config whenever the source reports a change, until
+// ctx is done. Reload failures are passed to onError, which may be nil.
+// It fails if the source does not implement Watcher.
+func (c *Config) Watch(ctx context.Context, onError func(error)) error {
+	watcher, ok := c.source.(Watcher)
+	if !ok {
+		return &Exception{message: fmt.Sprintf("source %T does not support watching", c.source)}
+	}
+	return watcher.Watch(ctx, c.configBasenames, func() {
+		if err := c.Reload(); err != nil && onError != nil {
+			onError(err)
+		}
+	})
 }
 
 func (c *Config) preprocess(config map[string]any) {
@@ -96,16 +284,36 @@ func (c *Config) deploy(configNew map[string]any, configDiffResult ConfigDiffRes
 }
 
 func (c *Config) apply(config map[string]any) []error {
-	configDiffResult := ConfigDiff(config, c.config)
-	c.preprocess(config)
-	valid := c.validate(config, configDiffResult)
-	if !valid {
-		return []error{fmt.Errorf("config %s failed validation", config)}
+	return c.applyTracked(config, make(provenance))
+}
+
+// applyTracked applies config, whose values originate from prov.
+func (c *Config) applyTracked(config map[string]any, prov provenance) []error {
+	configDiffResult, err := c.check(config, prov)
+	if err != nil {
+		return []error{err}
 	}
-	errs := c.deploy(config, configDiffResult)
-	if errs != nil {
+	if errs := c.deploy(config, configDiffResult); errs != nil {
 		return errs
 	}
 	c.config = config
+	c.provenance = prov
 	return nil
 }
+
+// check preprocesses and validates config, whose values originate from
+// prov, and returns how it differs from the applied config.
+func (c *Config) check(config map[string]any, prov provenance) (ConfigDiffResult, error) {
+	configDiffResult := ConfigDiff(config, c.config)
+	unprocessed := deepClone(config)
+	c.preprocess(config)
+	preprocessed := ConfigDiff(config, unprocessed)
+	preprocessorOrigin := func(string) Origin { return Origin{Layer: OriginPreprocessor} }
+	prov.record("", preprocessed.Changed, preprocessorOrigin)
+	prov.record("", preprocessed.Added, preprocessorOrigin)
+	prov.prune(config)
+	if !c.validate(config, configDiffResult) {
+		return configDiffResult, fmt.Errorf("config %s failed validation", config)
+	}
+	return configDiffResult, nil
+}
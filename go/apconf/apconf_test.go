@@ -109,6 +109,7 @@ func TestConfig(t *testing.T) {
 		configPreprocessors,
 		configDeployers,
 		nil,
+		WithTemplateMode(SnakeCaseTemplates),
 	)
 
 	// nolint: lll
@@ -0,0 +1,184 @@
+package apconf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Decoder splits the rendered content of a config file into its top-level
+// values. Each value is a document, a list of documents or nil. Errors in
+// a particular document should be reported as *ParseError with Document set.
+type Decoder func(content []byte) ([]any, error)
+
+// position is the line and column of a value in a config file.
+type position struct {
+	line   int
+	column int
+	// file is set for values included from another file, see $include.
+	file string
+}
+
+// positionDecoder is a Decoder that also returns, for each top-level
+// value, the positions of the values nested in it keyed by their dotted
+// path, e.g. "spec.num_workers" or "handlers.0". readFile reads the files
+// named by !file tags.
+type positionDecoder func(content []byte, readFile fileReader) ([]any, []map[string]position, error)
+
+// fileReader reads the file at a path, see Config.tagFileReader.
+type fileReader func(filePath string) ([]byte, error)
+
+// The registry of decoders by file extension, extended by RegisterDecoder.
+// nolint: gochecknoglobals
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]positionDecoder{
+		".yaml": decodeYaml,
+		".yml":  decodeYaml,
+		".json": withoutPositions(decodeJSON),
+		".toml": withoutPositions(decodeTOML),
+	}
+)
+
+// RegisterDecoder makes files with the given extension, e.g. ".hcl",
+// loadable with decoder. It replaces any decoder registered for ext.
+func RegisterDecoder(ext string, decoder Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[strings.ToLower(ext)] = withoutPositions(decoder)
+}
+
+func decoderFor(ext string) (positionDecoder, bool) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	decoder, ok := decoders[strings.ToLower(ext)]
+	return decoder, ok
+}
+
+func withoutPositions(decoder Decoder) positionDecoder {
+	return func(content []byte, _ fileReader) ([]any, []map[string]position, error) {
+		values, err := decoder(content)
+		return values, nil, err
+	}
+}
+
+func decodeYaml(content []byte, readFile fileReader) ([]any, []map[string]position, error) {
+	var docs []any
+	var docPositions []map[string]position
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for index := 0; ; index++ {
+		var node yaml.Node
+		if err := decoder.Decode(&node); err != nil {
+			if errors.Is(err, io.EOF) {
+				return docs, docPositions, nil
+			}
+			return nil, nil, &ParseError{Document: index, Err: err}
+		}
+		tags := newTagResolver(readFile)
+		if err := tags.resolve(&node, nil); err != nil {
+			return nil, nil, &ParseError{Document: index, Err: err}
+		}
+		var doc any
+		if err := node.Decode(&doc); err != nil {
+			return nil, nil, &ParseError{Document: index, Err: err}
+		}
+		tags.applyDurations(doc)
+		positions := make(map[string]position)
+		nodePositions(&node, "", positions)
+		docs = append(docs, doc)
+		docPositions = append(docPositions, positions)
+	}
+}
+
+// nodePositions records the positions of the values nested in node.
+// Values in mappings are located by their key.
+func nodePositions(node *yaml.Node, prefix string, positions map[string]position) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			nodePositions(child, prefix, positions)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			valuePath := joinPath(prefix, key.Value)
+			positions[valuePath] = position{line: key.Line, column: key.Column}
+			nodePositions(value, valuePath, positions)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			itemPath := joinPath(prefix, strconv.Itoa(i))
+			positions[itemPath] = position{line: item.Line, column: item.Column}
+			nodePositions(item, itemPath, positions)
+		}
+	}
+}
+
+// decodeJSON reads a stream of JSON values. Integral numbers are decoded
+// as int, like YAML does.
+func decodeJSON(content []byte) ([]any, error) {
+	var docs []any
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	for index := 0; ; index++ {
+		var doc any
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				return docs, nil
+			}
+			return nil, &ParseError{Document: index, Err: err}
+		}
+		docs = append(docs, normalizeNumbers(doc))
+	}
+}
+
+// decodeTOML reads a TOML file holding a single document.
+func decodeTOML(content []byte) ([]any, error) {
+	var doc map[string]any
+	if err := toml.Unmarshal(content, &doc); err != nil {
+		return nil, &ParseError{Err: err}
+	}
+	return []any{normalizeNumbers(doc)}, nil
+}
+
+// normalizeNumbers converts the numbers and lists produced by the JSON and
+// TOML decoders to the types produced by the YAML decoder.
+func normalizeNumbers(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = normalizeNumbers(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = normalizeNumbers(item)
+		}
+		return v
+	case []map[string]any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			list[i] = normalizeNumbers(item)
+		}
+		return list
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case int64:
+		return int(v)
+	default:
+		return v
+	}
+}
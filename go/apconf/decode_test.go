@@ -0,0 +1,71 @@
+package apconf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// nolint: funlen
+func TestDecoders(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  num_workers: 30
+`,
+		"base/db.json": `{"kind": "Config", "metadata": {"name": "db_config"},
+ "spec": {"port": 5432, "ratio": 0.5, "hosts": ["a", "b"]}}`,
+		"base/cache.toml": `kind = "Config"
+[metadata]
+name = "cache_config"
+[spec]
+size = 128
+[[spec.tiers]]
+name = "hot"
+`,
+		"base/notes.txt": "not a config",
+	})
+
+	cfg, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := msa{
+		"crawler_config": msa{"num_workers": 30},
+		"db_config":      msa{"port": 5432, "ratio": 0.5, "hosts": []any{"a", "b"}},
+		"cache_config":   msa{"size": 128, "tiers": []any{msa{"name": "hot"}}},
+	}
+	for name, spec := range expected {
+		doc, ok := cfg.config[name].(msa)
+		if !ok {
+			t.Errorf("Expected %s to be loaded", name)
+			continue
+		}
+		if !reflect.DeepEqual(doc["spec"], spec) {
+			t.Errorf("Expected %s spec %v, got %v", name, spec, doc["spec"])
+		}
+	}
+
+	t.Run("registered_decoder", func(t *testing.T) {
+		// Decodes "name=value" lines into a Config document
+		RegisterDecoder(".testkv", func(content []byte) ([]any, error) {
+			spec := msa{}
+			for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+				key, value, _ := strings.Cut(line, "=")
+				spec[key] = value
+			}
+			return []any{msa{"kind": "Config", "metadata": msa{"name": "kv_config"}, "spec": spec}}, nil
+		})
+		root := writeProfiles(t, map[string]string{"base/app.testkv": "mode=fast\n"})
+		cfg, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if mode := cfg.config["kv_config"].(msa)["spec"].(msa)["mode"]; mode != "fast" {
+			t.Errorf("Expected mode fast, got %v", mode)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package apconf
+
+import "fmt"
+
+// DirNotFoundError is returned when a profile directory does not exist.
+type DirNotFoundError struct {
+	Dir string
+}
+
+func (e *DirNotFoundError) Error() string {
+	return fmt.Sprintf("config directory %s does not exist", e.Dir)
+}
+
+// ReadError is returned when a profile directory or one of its files
+// cannot be read.
+type ReadError struct {
+	Dir  string
+	File string
+	Err  error
+}
+
+func (e *ReadError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("failed to read config directory %s: %v", e.Dir, e.Err)
+	}
+	return fmt.Sprintf("failed to read config file %s in %s: %v", e.File, e.Dir, e.Err)
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// ParseError is returned when a config file is not valid YAML.
+// Document is the zero-based index of the failing document in the stream.
+type ParseError struct {
+	Dir      string
+	File     string
+	Document int
+	Err      error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf(
+		"failed to parse document %d of config file %s in %s: %v",
+		e.Document, e.File, e.Dir, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// UndefinedParamError is the cause of a *TemplateError when a template
+// rendered with WithStrictTemplates uses an undefined param, or when a
+// Jinja template reads an attribute or an item of one.
+type UndefinedParamError struct {
+	Line  int
+	Param string
+}
+
+func (e *UndefinedParamError) Error() string {
+	return fmt.Sprintf("line %d: undefined template param %s", e.Line, e.Param)
+}
+
+// TemplateError is returned when a config file cannot be rendered
+// with the template params.
+type TemplateError struct {
+	Dir  string
+	File string
+	Err  error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("failed to render config file %s in %s: %v", e.File, e.Dir, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// ConditionError is returned when the metadata.when expression of a
+// document cannot be evaluated.
+type ConditionError struct {
+	Dir      string
+	File     string
+	Document string
+	Expr     string
+	Err      error
+}
+
+func (e *ConditionError) Error() string {
+	return fmt.Sprintf(
+		"failed to evaluate when expression %q of document %s in config file %s in %s: %v",
+		e.Expr, e.Document, e.File, e.Dir, e.Err)
+}
+
+func (e *ConditionError) Unwrap() error {
+	return e.Err
+}
+
+// IncludeError is returned when a $include directive in a config file
+// is invalid or includes itself, directly or indirectly.
+type IncludeError struct {
+	Dir     string
+	File    string
+	Include string
+	Err     error
+}
+
+func (e *IncludeError) Error() string {
+	return fmt.Sprintf("failed to include %s in config file %s in %s: %v",
+		e.Include, e.File, e.Dir, e.Err)
+}
+
+func (e *IncludeError) Unwrap() error {
+	return e.Err
+}
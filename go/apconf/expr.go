@@ -0,0 +1,407 @@
+package apconf
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expressions of metadata.when, e.g. `proc_role == "worker" && num_cpus > 4`.
+// They support string, number, boolean and null literals, identifiers
+// naming template params (dotted for nested maps), the comparison
+// operators ==, !=, <, <=, >, >=, the logical operators &&, || and !,
+// and parentheses.
+
+type exprNode interface {
+	eval(params map[string]any) (any, error)
+}
+
+type literalNode struct {
+	value any
+}
+
+type identNode struct {
+	path []string
+}
+
+type unaryNode struct {
+	operand exprNode
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+type exprToken struct {
+	kind  string // "op", "ident", "string", "number" or "eof"
+	text  string
+	value any
+}
+
+// parseExpr parses a when expression.
+func parseExpr(expr string) (exprNode, error) {
+	tokens, err := tokenizeExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != "eof" {
+		return nil, fmt.Errorf("unexpected %q", tok.text)
+	}
+	return node, nil
+}
+
+// evalCondition evaluates a when expression to a boolean.
+func evalCondition(expr string, params map[string]any) (bool, error) {
+	node, err := parseExpr(expr)
+	if err != nil {
+		return false, err
+	}
+	value, err := node.eval(params)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected a boolean result, got %v", value)
+	}
+	return result, nil
+}
+
+func tokenizeExpr(expr string) ([]exprToken, error) {
+	var tokens []exprToken
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string starting at %d", i)
+			}
+			text := string(runes[i : end+1])
+			value := string(runes[i+1 : end])
+			if r == '"' {
+				unquoted, err := strconv.Unquote(text)
+				if err != nil {
+					return nil, fmt.Errorf("invalid string %s", text)
+				}
+				value = unquoted
+			}
+			tokens = append(tokens, exprToken{kind: "string", text: text, value: value})
+			i = end + 1
+		case unicode.IsDigit(r):
+			end := i
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			text := string(runes[i:end])
+			var value any
+			if n, err := strconv.Atoi(text); err == nil {
+				value = n
+			} else if f, err := strconv.ParseFloat(text, 64); err == nil {
+				value = f
+			} else {
+				return nil, fmt.Errorf("invalid number %s", text)
+			}
+			tokens = append(tokens, exprToken{kind: "number", text: text, value: value})
+			i = end
+		case unicode.IsLetter(r) || r == '_':
+			end := i
+			for end < len(runes) &&
+				(unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) ||
+					runes[end] == '_' || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, exprToken{kind: "ident", text: string(runes[i:end])})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"} {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q", r)
+			}
+			tokens = append(tokens, exprToken{kind: "op", text: op})
+			i += len(op)
+		}
+	}
+	return append(tokens, exprToken{kind: "eof", text: "end of expression"}), nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != "eof" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) isOp(ops ...string) bool {
+	tok := p.peek()
+	if tok.kind != "op" {
+		return false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.parseBinary(p.parseComparison, "&&")
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	return p.parseBinary(p.parseUnary, "==", "!=", "<", "<=", ">", ">=")
+}
+
+func (p *exprParser) parseBinary(
+	operand func() (exprNode, error), ops ...string) (exprNode, error) {
+
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(ops...) {
+		op := p.next().text
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.isOp("!") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case "string", "number":
+		return &literalNode{value: tok.value}, nil
+	case "ident":
+		switch tok.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null", "nil":
+			return &literalNode{value: nil}, nil
+		}
+		return &identNode{path: strings.Split(tok.text, ".")}, nil
+	case "op":
+		if tok.text == "(" {
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.isOp(")") {
+				return nil, fmt.Errorf("expected ) but got %q", p.peek().text)
+			}
+			p.next()
+			return node, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q", tok.text)
+}
+
+func (n *literalNode) eval(map[string]any) (any, error) {
+	return n.value, nil
+}
+
+// eval looks the identifier up in params. Top-level names also match
+// PascalCase keys, e.g. proc_role finds ProcRole.
+func (n *identNode) eval(params map[string]any) (any, error) {
+	value, ok := params[n.path[0]]
+	if !ok {
+		value, ok = params[toPascalCase(n.path[0])]
+	}
+	if !ok {
+		return nil, fmt.Errorf("undefined parameter %s", n.path[0])
+	}
+	for i, key := range n.path[1:] {
+		m, isMap := value.(map[string]any)
+		if !isMap {
+			return nil, fmt.Errorf("%s is not a map", strings.Join(n.path[:i+1], "."))
+		}
+		if value, ok = m[key]; !ok {
+			return nil, fmt.Errorf("undefined parameter %s", strings.Join(n.path[:i+2], "."))
+		}
+	}
+	return value, nil
+}
+
+func (n *unaryNode) eval(params map[string]any) (any, error) {
+	value, err := n.operand.eval(params)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return nil, fmt.Errorf("! expects a boolean, got %v", value)
+	}
+	return !b, nil
+}
+
+func (n *binaryNode) eval(params map[string]any) (any, error) {
+	left, err := n.left.eval(params)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s expects booleans, got %v", n.op, left)
+		}
+		if (n.op == "&&" && !l) || (n.op == "||" && l) {
+			return l, nil
+		}
+		right, err := n.right.eval(params)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s expects booleans, got %v", n.op, right)
+		}
+		return r, nil
+	}
+
+	right, err := n.right.eval(params)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return valuesEqual(left, right), nil
+	case "!=":
+		return !valuesEqual(left, right), nil
+	}
+
+	cmp, err := compareValues(left, right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		i, _ := ToInt(v)
+		return float64(i), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// valuesEqual compares numbers by value and other values, including maps
+// and lists, deeply.
+func valuesEqual(left, right any) bool {
+	if l, ok := toFloat(left); ok {
+		r, ok := toFloat(right)
+		return ok && l == r
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+func compareValues(left, right any) (int, error) {
+	if l, ok := toFloat(left); ok {
+		if r, ok := toFloat(right); ok {
+			switch {
+			case l < r:
+				return -1, nil
+			case l > r:
+				return 1, nil
+			default:
+				return 0, nil
+			}
+		}
+	}
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %v and %v", left, right)
+}
+
+// documentActive reports whether doc is active, i.e. it has no
+// metadata.when expression or the expression holds for params.
+func documentActive(doc map[string]any, params map[string]any) (bool, *ConditionError) {
+	metadata, _ := doc["metadata"].(map[string]any)
+	rawExpr, exists := metadata["when"]
+	if !exists || rawExpr == nil {
+		return true, nil
+	}
+	docName := fmt.Sprint(metadata["name"])
+	expr, ok := rawExpr.(string)
+	if !ok {
+		return false, &ConditionError{Document: docName, Expr: fmt.Sprint(rawExpr),
+			Err: fmt.Errorf("metadata.when must be a string, got %T", rawExpr)}
+	}
+	active, err := evalCondition(expr, params)
+	if err != nil {
+		return false, &ConditionError{Document: docName, Expr: expr, Err: err}
+	}
+	return active, nil
+}
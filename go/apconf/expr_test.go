@@ -0,0 +1,109 @@
+package apconf
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestEvalCondition(t *testing.T) {
+	params := map[string]any{
+		"proc_role": "worker",
+		"NumCpus":   8,
+		"ratio":     0.5,
+		"debug":     false,
+		"db":        map[string]any{"host": "localhost"},
+		"replica":   map[string]any{"host": "localhost"},
+		"hosts":     []any{"a", "b"},
+	}
+	cases := []struct {
+		expr   string
+		result bool
+	}{
+		{`proc_role == "worker" && num_cpus > 4`, true},
+		{`proc_role == 'worker' && num_cpus > 8`, false},
+		{`proc_role != "worker" || num_cpus >= 8`, true},
+		{`!debug && ratio < 1`, true},
+		{`!(debug || ratio <= 0.5)`, false},
+		{`db.host == "localhost"`, true},
+		{`num_cpus == 8.0`, true},
+		{`proc_role == 8`, false},
+		{`!debug || missing`, true},
+		{`db == replica`, true},
+		{`db != hosts`, true},
+		{`hosts == hosts && db != "localhost"`, true},
+	}
+	for _, tc := range cases {
+		result, err := evalCondition(tc.expr, params)
+		if err != nil {
+			t.Errorf("Failed to evaluate %s: %v", tc.expr, err)
+			continue
+		}
+		if result != tc.result {
+			t.Errorf("Expected %s to be %v", tc.expr, tc.result)
+		}
+	}
+
+	for _, invalid := range []string{
+		`missing == 1`,
+		`proc_role ==`,
+		`(num_cpus > 4`,
+		`num_cpus`,
+		`proc_role > 4`,
+		`db.host.name == "x"`,
+		`proc_role = "worker"`,
+	} {
+		if _, err := evalCondition(invalid, params); err == nil {
+			t.Errorf("Expected %s to fail", invalid)
+		}
+	}
+}
+
+func TestWhenCondition(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec: {}
+---
+kind: Config
+metadata:
+  name: worker_config
+  when: proc_role == "worker" && num_cpus > 4
+spec: {}
+`,
+	})
+	load := func(params map[string]any) []string {
+		t.Helper()
+		cfg, err := LoadConfig(root, []string{"base"}, params, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		var names []string
+		for name := range cfg.config {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	if names := load(map[string]any{"proc_role": "worker", "num_cpus": 8}); len(names) != 2 {
+		t.Errorf("Expected both documents, got %v", names)
+	}
+	if names := load(map[string]any{"proc_role": "worker", "num_cpus": 2}); len(names) != 1 {
+		t.Errorf("Expected worker_config to be skipped, got %v", names)
+	}
+
+	_, err := LoadConfig(root, []string{"base"}, map[string]any{"proc_role": "worker"}, nil, nil, nil)
+	var condErr *ConditionError
+	if !errors.As(err, &condErr) {
+		t.Fatalf("Expected a ConditionError, got %v", err)
+	}
+	if condErr.File != "crawl.yaml" || condErr.Document != "worker_config" {
+		t.Errorf("Expected the error to point to crawl.yaml and worker_config, got %v", condErr)
+	}
+	if !strings.Contains(err.Error(), "num_cpus") {
+		t.Errorf("Expected the error to name the undefined parameter, got %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package apconf
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ExtendsError is returned when the metadata.extends of a document names
+// a missing document or forms a cycle.
+type ExtendsError struct {
+	Kind string
+	Name string
+	Err  error
+}
+
+func (e *ExtendsError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Kind, e.Name, e.Err)
+}
+
+func (e *ExtendsError) Unwrap() error {
+	return e.Err
+}
+
+// documentExtends returns the metadata.extends of a document, the name of
+// the document of the same kind it inherits its spec from.
+func documentExtends(doc map[string]any) (string, bool, error) {
+	metadata, _ := doc["metadata"].(map[string]any)
+	rawBase, exists := metadata["extends"]
+	if !exists || rawBase == nil {
+		return "", false, nil
+	}
+	base, ok := rawBase.(string)
+	if !ok || base == "" {
+		return "", false, fmt.Errorf("metadata.extends must be a document name, got %v", rawBase)
+	}
+	return base, true, nil
+}
+
+// resolveExtends deep-merges the spec of every document that sets
+// metadata.extends over the spec of its base, which may extend another
+// document in turn. Inherited Config values keep the history of the base
+// values in prov.
+func resolveExtends(finalDict map[string]any, prov provenance) error {
+	kinds := make([]string, 0, len(finalDict))
+	for kind := range finalDict {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		docs, _ := finalDict[kind].(map[string]any)
+		r := extendsResolver{kind: kind, docs: docs, resolved: make(map[string]bool)}
+		if kind == "Config" {
+			r.prov = prov
+		}
+		names := make([]string, 0, len(docs))
+		for name := range docs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := r.resolve(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type extendsResolver struct {
+	kind     string
+	docs     map[string]any
+	prov     provenance
+	resolved map[string]bool
+	visiting []string
+}
+
+func (r *extendsResolver) resolve(name string) error {
+	if r.resolved[name] {
+		return nil
+	}
+	if i := slices.Index(r.visiting, name); i >= 0 {
+		cycle := append(slices.Clone(r.visiting[i:]), name)
+		return &ExtendsError{Kind: r.kind, Name: name,
+			Err: fmt.Errorf("metadata.extends cycle: %s", strings.Join(cycle, " -> "))}
+	}
+	doc, _ := r.docs[name].(map[string]any)
+	base, extends, err := documentExtends(doc)
+	if err != nil {
+		return &ExtendsError{Kind: r.kind, Name: name, Err: err}
+	}
+	if !extends {
+		r.resolved[name] = true
+		return nil
+	}
+	if _, ok := r.docs[base].(map[string]any); !ok {
+		return &ExtendsError{Kind: r.kind, Name: name,
+			Err: fmt.Errorf("metadata.extends names unknown document %s", base)}
+	}
+
+	r.visiting = append(r.visiting, name)
+	if err := r.resolve(base); err != nil {
+		return err
+	}
+	r.visiting = r.visiting[:len(r.visiting)-1]
+
+	baseDoc := r.docs[base].(map[string]any)
+	baseSpec, _ := baseDoc["spec"].(map[string]any)
+	spec, _ := doc["spec"].(map[string]any)
+	merged, err := mergeMaps(baseSpec, spec)
+	if err != nil {
+		return &ExtendsError{Kind: r.kind, Name: name, Err: err}
+	}
+	derived := make(map[string]any, len(doc))
+	for key, value := range doc {
+		derived[key] = value
+	}
+	derived["spec"] = merged
+	r.docs[name] = derived
+	if r.prov != nil {
+		r.inheritProvenance(base+".spec", name+".spec")
+	}
+	r.resolved[name] = true
+	return nil
+}
+
+// inheritProvenance copies the history of the leaves under basePath to
+// the corresponding leaves under derivedPath that have none.
+func (r *extendsResolver) inheritProvenance(basePath, derivedPath string) {
+	inherited := make(provenance)
+	for leaf, origins := range r.prov {
+		rest, ok := strings.CutPrefix(leaf, basePath+".")
+		if !ok {
+			continue
+		}
+		derivedLeaf := derivedPath + "." + rest
+		if _, exists := r.prov[derivedLeaf]; !exists {
+			inherited[derivedLeaf] = slices.Clone(origins)
+		}
+	}
+	for leaf, origins := range inherited {
+		r.prov[leaf] = origins
+	}
+}
@@ -0,0 +1,135 @@
+package apconf
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtends(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  num_workers: 4
+  http:
+    timeout: 10
+    retries: 3
+---
+kind: Config
+metadata:
+  name: slow_crawler_config
+  extends: crawler_config
+spec:
+  http:
+    timeout: 60
+---
+kind: Config
+metadata:
+  name: single_slow_crawler_config
+  extends: slow_crawler_config
+spec:
+  num_workers: 1
+`,
+		// The base is changed by a later profile, after the derived
+		// documents were loaded.
+		"override/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  http:
+    retries: 5
+`,
+	})
+
+	cfg, err := LoadConfig(root, []string{"base", "override"}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	spec := func(name string) any {
+		return cfg.config[name].(map[string]any)["spec"]
+	}
+	expected := map[string]any{
+		"num_workers": 4,
+		"http":        map[string]any{"timeout": 60, "retries": 5},
+	}
+	if got := spec("slow_crawler_config"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	expected["num_workers"] = 1
+	if got := spec("single_slow_crawler_config"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	origins := cfg.Explain("single_slow_crawler_config.spec.http.retries")
+	if len(origins) != 2 || origins[1].Profile != "override" || origins[1].Value != 5 {
+		t.Errorf("Expected the history of the inherited value, got %v", origins)
+	}
+}
+
+func TestExtendsWithOverlay(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yaml": `kind: Config
+metadata:
+  name: base
+spec:
+  queue: "007"
+---
+kind: Config
+metadata:
+  name: derived
+  extends: base
+spec: {}
+`,
+	})
+	// The overlay sees the inherited value and keeps its type.
+	t.Setenv("PX__DERIVED__SPEC__QUEUE", "008")
+
+	cfg, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil, WithEnvOverlay("PX"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if queue := cfg.config["derived"].(map[string]any)["spec"].(map[string]any)["queue"]; queue != "008" {
+		t.Errorf("Expected queue to be \"008\", got %#v", queue)
+	}
+	if queue := cfg.config["base"].(map[string]any)["spec"].(map[string]any)["queue"]; queue != "007" {
+		t.Errorf("Expected the base queue to be unchanged, got %#v", queue)
+	}
+}
+
+func TestExtendsErrors(t *testing.T) {
+	cases := map[string]string{
+		"unknown document": `kind: Config
+metadata:
+  name: crawler_config
+  extends: no_such_config
+spec: {}
+`,
+		"cycle": `kind: Config
+metadata:
+  name: a_config
+  extends: b_config
+spec: {}
+---
+kind: Config
+metadata:
+  name: b_config
+  extends: a_config
+spec: {}
+`,
+	}
+	for name, content := range cases {
+		root := writeProfiles(t, map[string]string{"base/crawl.yaml": content})
+		_, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil)
+		var extendsErr *ExtendsError
+		if !errors.As(err, &extendsErr) {
+			t.Errorf("%s: expected an ExtendsError, got %v", name, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package apconf
+
+import (
+	"path"
+	"strings"
+)
+
+// fileFilter selects the documents of a profile to load.
+//
+// Patterns ending with a slash, e.g. "_drafts/", match directories at any
+// depth. Other patterns containing a slash are matched against the path
+// relative to the profile, those without one, e.g. "*.example.yaml",
+// against the file name. Patterns use path.Match syntax.
+type fileFilter struct {
+	recursive bool
+	include   []string
+	exclude   []string
+}
+
+// match tells whether the document named name should be loaded.
+func (f fileFilter) match(name string) (bool, error) {
+	dirs := strings.Split(name, "/")
+	dirs = dirs[:len(dirs)-1]
+	if len(dirs) > 0 && !f.recursive {
+		return false, nil
+	}
+	for _, dir := range dirs {
+		if strings.HasPrefix(dir, ".") {
+			return false, nil
+		}
+	}
+	if strings.HasPrefix(path.Base(name), ".") {
+		return false, nil
+	}
+
+	for _, pattern := range f.exclude {
+		matched, err := matchPattern(pattern, name, dirs)
+		if err != nil || matched {
+			return false, err
+		}
+	}
+	if len(f.include) == 0 {
+		return true, nil
+	}
+	for _, pattern := range f.include {
+		matched, err := matchPattern(pattern, name, dirs)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+	return false, nil
+}
+
+func matchPattern(pattern, name string, dirs []string) (bool, error) {
+	switch {
+	case strings.HasSuffix(pattern, "/"):
+		for _, dir := range dirs {
+			if matched, err := path.Match(strings.TrimSuffix(pattern, "/"), dir); err != nil || matched {
+				return matched, err
+			}
+		}
+		return false, nil
+	case strings.Contains(pattern, "/"):
+		return path.Match(pattern, name)
+	default:
+		return path.Match(pattern, path.Base(name))
+	}
+}
@@ -0,0 +1,97 @@
+package apconf
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SetFlags collects the overrides given by repeatable command-line flags
+// --set path=value and --set-file path=file, e.g.
+// --set crawler_config.spec.num_workers=8. Values of --set are parsed as
+// YAML scalars, --set-file sets the content of the file as a string.
+type SetFlags struct {
+	overrides []setOverride
+}
+
+type setOverride struct {
+	flag  string
+	path  []string
+	value any
+}
+
+type setFlagValue struct {
+	flags    *SetFlags
+	name     string
+	fromFile bool
+}
+
+// RegisterSetFlags registers the --set and --set-file flags on fs.
+func RegisterSetFlags(fs *flag.FlagSet) *SetFlags {
+	flags := &SetFlags{}
+	fs.Var(&setFlagValue{flags: flags, name: "set"}, "set",
+		"override a config value, path=value (repeatable)")
+	fs.Var(&setFlagValue{flags: flags, name: "set-file", fromFile: true}, "set-file",
+		"override a config value with the content of a file, path=file (repeatable)")
+	return flags
+}
+
+func (v *setFlagValue) String() string {
+	if v.flags == nil {
+		return ""
+	}
+	var values []string
+	for _, override := range v.flags.overrides {
+		if override.flag == v.name {
+			values = append(values, fmt.Sprintf("%s=%v", strings.Join(override.path, "."), override.value))
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+func (v *setFlagValue) Set(arg string) error {
+	key, raw, ok := strings.Cut(arg, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected path=value, got %q", arg)
+	}
+
+	var value any
+	if v.fromFile {
+		content, err := os.ReadFile(raw)
+		if err != nil {
+			return err
+		}
+		value = string(content)
+	} else {
+		var err error
+		if value, err = parseYamlValue(raw); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+	}
+
+	v.flags.overrides = append(v.flags.overrides, setOverride{
+		flag:  v.name,
+		path:  strings.Split(key, "."),
+		value: value,
+	})
+	return nil
+}
+
+// Apply sets the overridden values in config, in command-line order.
+func (s *SetFlags) Apply(config map[string]any) error {
+	return s.apply(config, nil)
+}
+
+func (s *SetFlags) apply(config map[string]any, overrides map[string]any) error {
+	for _, override := range s.overrides {
+		err := setPath(config, override.path, func(any, bool) (any, error) {
+			recordOverride(overrides, override.path, override.value)
+			return cloneValue(override.value), nil
+		})
+		if err != nil {
+			return fmt.Errorf("invalid --%s %s: %w", override.flag, strings.Join(override.path, "."), err)
+		}
+	}
+	return nil
+}
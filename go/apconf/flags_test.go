@@ -0,0 +1,59 @@
+package apconf
+
+import (
+	"flag"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetFlags(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  num_workers: 30
+  queue: default
+`,
+		"files/banner.txt": "hello\n",
+	})
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	setFlags := RegisterSetFlags(fs)
+	err := fs.Parse([]string{
+		"--set", "crawler_config.spec.num_workers=8",
+		"--set", "crawler_config.spec.debug=true",
+		"--set-file", "crawler_config.spec.banner=" + filepath.Join(root, "files", "banner.txt"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	t.Setenv("APCONF__CRAWLER_CONFIG__SPEC__NUM_WORKERS", "50")
+	cfg, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil,
+		WithEnvOverlay("APCONF"), WithSetFlags(setFlags))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	spec := cfg.config["crawler_config"].(msa)["spec"].(msa)
+	numWorkers, err := ToInt(spec["num_workers"])
+	if err != nil || numWorkers != 8 {
+		t.Errorf("Expected 'num_workers' to be 8, but got %v", spec["num_workers"])
+	}
+	if _, ok := spec["num_workers"].(int); !ok {
+		t.Errorf("Expected 'num_workers' to be an int, got %T", spec["num_workers"])
+	}
+	if spec["debug"] != true || spec["banner"] != "hello\n" || spec["queue"] != "default" {
+		t.Errorf("Unexpected spec %v", spec)
+	}
+
+	t.Run("malformed", func(t *testing.T) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		RegisterSetFlags(fs)
+		if err := fs.Parse([]string{"--set", "no_value"}); err == nil {
+			t.Errorf("Expected an error for a --set without value")
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package apconf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TemplateFuncs returns the functions available to every config template.
+// Like in Sprig, the value a function works on comes last so that it can
+// be piped, e.g. {{ .LogDir | default "/var/log" }}.
+//
+//	default d v     v, or d if v is empty
+//	required msg v  v, or an error with msg if v is empty
+//	env name        the value of an environment variable
+//	hostname        the host name
+//	upper, lower, trim, quote s
+//	join sep list   the items of list joined by sep
+//	pathJoin elem...
+//	toYaml v        v as YAML, without a trailing newline
+//	indent n s      s with every line indented by n spaces
+//	nindent n s     like indent, preceded by a newline
+//	add, sub, mul, div, mod a b
+//	max, min a b    integer arithmetic
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"default":  defaultValue,
+		"required": required,
+		"env":      os.Getenv,
+		"hostname": os.Hostname,
+		"upper":    strings.ToUpper,
+		"lower":    strings.ToLower,
+		"trim":     strings.TrimSpace,
+		"quote":    func(s any) string { return fmt.Sprintf("%q", fmt.Sprint(s)) },
+		"join":     join,
+		"pathJoin": pathJoin,
+		"toYaml":   toYaml,
+		"indent":   indent,
+		"nindent":  func(n int, s string) string { return "\n" + indent(n, s) },
+		"add":      arithmetic(func(a, b int) (int, error) { return a + b, nil }),
+		"sub":      arithmetic(func(a, b int) (int, error) { return a - b, nil }),
+		"mul":      arithmetic(func(a, b int) (int, error) { return a * b, nil }),
+		"div":      arithmetic(divide),
+		"mod":      arithmetic(modulo),
+		"max":      arithmetic(func(a, b int) (int, error) { return max(a, b), nil }),
+		"min":      arithmetic(func(a, b int) (int, error) { return min(a, b), nil }),
+	}
+}
+
+// WithTemplateFuncs makes funcs available to the config templates, in
+// addition to TemplateFuncs. They replace functions of the same name.
+func WithTemplateFuncs(funcs template.FuncMap) Option {
+	return func(c *Config) {
+		if c.templateFuncs == nil {
+			c.templateFuncs = make(template.FuncMap)
+		}
+		for name, fn := range funcs {
+			c.templateFuncs[name] = fn
+		}
+	}
+}
+
+// templateFuncMap returns TemplateFuncs with the functions given
+// WithTemplateFuncs.
+func (c *Config) templateFuncMap() template.FuncMap {
+	funcs := TemplateFuncs()
+	for name, fn := range c.templateFuncs {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// isEmpty tells whether value is missing or the zero value of its type,
+// or an empty map, list or string.
+func isEmpty(value any) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.String:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
+func defaultValue(def, value any) any {
+	if isEmpty(value) {
+		return def
+	}
+	return value
+}
+
+func required(msg string, value any) (any, error) {
+	if isEmpty(value) {
+		return nil, errors.New(msg)
+	}
+	return value, nil
+}
+
+func join(sep string, list any) (string, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return "", fmt.Errorf("join expects a list, got %T", list)
+	}
+	items := make([]string, v.Len())
+	for i := range items {
+		items[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(items, sep), nil
+}
+
+func pathJoin(elems ...any) string {
+	parts := make([]string, len(elems))
+	for i, elem := range elems {
+		parts[i] = fmt.Sprint(elem)
+	}
+	return filepath.Join(parts...)
+}
+
+func toYaml(value any) (string, error) {
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+func arithmetic(op func(a, b int) (int, error)) func(a, b any) (int, error) {
+	return func(a, b any) (int, error) {
+		x, err := ToInt(a)
+		if err != nil {
+			return 0, err
+		}
+		y, err := ToInt(b)
+		if err != nil {
+			return 0, err
+		}
+		return op(x, y)
+	}
+}
+
+func divide(a, b int) (int, error) {
+	if b == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return a / b, nil
+}
+
+func modulo(a, b int) (int, error) {
+	if b == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return a % b, nil
+}
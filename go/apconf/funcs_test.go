@@ -0,0 +1,75 @@
+package apconf
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	t.Setenv("APCONF_TEST_REGION", "eu")
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("Failed to get the host name: %v", err)
+	}
+	params := map[string]any{
+		"Name":    "crawler",
+		"Workers": 4,
+		"Hosts":   []any{"a", "b"},
+		"Root":    "/srv",
+		"Labels":  map[string]any{"tier": "gold"},
+	}
+	cases := map[string]string{
+		`{{ .Missing | default "x" }}`:                     "x",
+		`{{ .Name | default "x" }}`:                        "crawler",
+		`{{ .Name | upper }}`:                              "CRAWLER",
+		`{{ env "APCONF_TEST_REGION" }}`:                   "eu",
+		`{{ hostname }}`:                                   host,
+		`{{ .Hosts | join "," }}`:                          "a,b",
+		`{{ pathJoin .Root "log" .Name }}`:                 "/srv/log/crawler",
+		`{{ add .Workers 2 }} {{ mul .Workers 3 }}`:        "6 12",
+		`{{ div 7 2 }} {{ mod 7 2 }} {{ max 1 .Workers }}`: "3 1 4",
+		"labels:{{ .Labels | toYaml | nindent 2 }}":        "labels:\n  tier: gold",
+	}
+	for tmpl, expected := range cases {
+		rendered, _, err := renderTemplate([]byte(tmpl), params, TemplateFuncs(), false)
+		if err != nil {
+			t.Errorf("Failed to render %s: %v", tmpl, err)
+			continue
+		}
+		if string(rendered) != expected {
+			t.Errorf("Expected %s to render %q, got %q", tmpl, expected, rendered)
+		}
+	}
+
+	for _, tmpl := range []string{`{{ .Missing | required "missing is required" }}`, `{{ div 1 0 }}`} {
+		if _, _, err := renderTemplate([]byte(tmpl), params, TemplateFuncs(), false); err == nil {
+			t.Errorf("Expected %s to fail", tmpl)
+		}
+	}
+}
+
+func TestWithTemplateFuncs(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  region: {{ region }}
+  name: {{ upper "crawler" }}
+`,
+	})
+	cfg, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil,
+		WithTemplateFuncs(template.FuncMap{
+			"region": func() string { return "eu" },
+			"upper":  strings.ToLower,
+		}))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	spec := cfg.config["crawler_config"].(map[string]any)["spec"].(map[string]any)
+	if spec["region"] != "eu" || spec["name"] != "crawler" {
+		t.Errorf("Expected the application functions to be used, got %v", spec)
+	}
+}
@@ -0,0 +1,197 @@
+package apconf
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// includeDirective replaces the map holding it with the value of a
+// fragment file, e.g. "encoder: {$include: ../shared/zap_encoder.yaml}".
+// The path is relative to the directory of the including profile, so
+// "../shared/x.yaml" names x.yaml in the profile shared. Fragments are
+// rendered like config files and hold a single value, which may
+// include other fragments. The other keys of the map are merged over a
+// map fragment, e.g. "{$include: encoder.yaml, timeKey: ts}".
+const includeDirective = "$include"
+
+type includeExpander struct {
+	c *Config
+	// positions locates the values of the document, see document.positions.
+	positions map[string]position
+}
+
+// expandIncludes replaces the $include directives in doc, which was
+// loaded from the file name of profile.
+func (c *Config) expandIncludes(doc *document, profile, name string) error {
+	e := &includeExpander{c: c, positions: doc.positions}
+	if e.positions == nil {
+		e.positions = make(map[string]position)
+	}
+	expanded, err := e.expand(doc.content, "", profile, name, []string{path.Join(profile, name)})
+	if err != nil {
+		return err
+	}
+	content, ok := expanded.(map[string]any)
+	if !ok {
+		return &IncludeError{
+			Dir:  sourceLocation(c.source, profile),
+			File: name,
+			Err:  fmt.Errorf("a document must include a mapping, got %T", expanded),
+		}
+	}
+	doc.content = content
+	doc.positions = e.positions
+	return nil
+}
+
+// expand replaces the $include directives in value, located at valuePath
+// in the file name of profile. stack holds the files being included.
+func (e *includeExpander) expand(
+	value any, valuePath, profile, name string, stack []string) (any, error) {
+
+	switch v := value.(type) {
+	case map[string]any:
+		if include, ok := v[includeDirective]; ok {
+			return e.include(v, include, valuePath, profile, name, stack)
+		}
+		for key, item := range v {
+			expanded, err := e.expand(item, joinPath(valuePath, key), profile, name, stack)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = expanded
+		}
+	case []any:
+		for i, item := range v {
+			expanded, err := e.expand(item, joinPath(valuePath, strconv.Itoa(i)), profile, name, stack)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = expanded
+		}
+	}
+	return value, nil
+}
+
+func (e *includeExpander) include(
+	m map[string]any, include any, valuePath, profile, name string, stack []string) (any, error) {
+
+	includeErr := func(err error) error {
+		return &IncludeError{
+			Dir:     sourceLocation(e.c.source, profile),
+			File:    name,
+			Include: fmt.Sprint(include),
+			Err:     err,
+		}
+	}
+	includePath, ok := include.(string)
+	if !ok {
+		return nil, includeErr(fmt.Errorf("%s must be a path, got %T", includeDirective, include))
+	}
+	fragmentProfile, fragmentName, err := resolveInclude(profile, includePath)
+	if err != nil {
+		return nil, includeErr(err)
+	}
+	key := path.Join(fragmentProfile, fragmentName)
+	if slices.Contains(stack, key) {
+		return nil, includeErr(fmt.Errorf("include cycle: %s", strings.Join(append(stack, key), " -> ")))
+	}
+
+	decode, ok := decoderFor(path.Ext(fragmentName))
+	if !ok {
+		return nil, includeErr(errors.New("no decoder is registered for the file extension"))
+	}
+	fragment, fragmentPositions, err := e.c.loadFragment(fragmentProfile, fragmentName, decode)
+	if err != nil {
+		return nil, err
+	}
+	delete(e.positions, joinPath(valuePath, includeDirective))
+	file := filepath.Join(
+		sourceLocation(e.c.source, fragmentProfile), filepath.FromSlash(fragmentName))
+	for subPath, pos := range fragmentPositions {
+		// Positions already present belong to the keys next to $include,
+		// which take precedence over the fragment
+		fullPath := joinPath(valuePath, subPath)
+		if _, exists := e.positions[fullPath]; !exists {
+			pos.file = file
+			e.positions[fullPath] = pos
+		}
+	}
+	fragment, err = e.expand(fragment, valuePath, fragmentProfile, fragmentName, append(stack, key))
+	if err != nil {
+		return nil, err
+	}
+
+	rest := make(map[string]any, len(m)-1)
+	for key, item := range m {
+		if key == includeDirective {
+			continue
+		}
+		expanded, err := e.expand(item, joinPath(valuePath, key), profile, name, stack)
+		if err != nil {
+			return nil, err
+		}
+		rest[key] = expanded
+	}
+	if len(rest) == 0 {
+		return fragment, nil
+	}
+	fragmentMap, ok := fragment.(map[string]any)
+	if !ok {
+		return nil, includeErr(fmt.Errorf("cannot merge keys into a fragment of type %T", fragment))
+	}
+	return mergeMaps(fragmentMap, rest)
+}
+
+// resolveInclude returns the profile and the name within it of the
+// fragment at includePath, relative to the directory of profile.
+func resolveInclude(profile, includePath string) (string, string, error) {
+	if path.IsAbs(includePath) {
+		return "", "", errors.New("the path must be relative to the profile directory")
+	}
+	fragmentProfile, fragmentName, ok := strings.Cut(path.Join(profile, includePath), "/")
+	if !ok || fragmentProfile == ".." {
+		return "", "", errors.New("the path must name a file in a profile directory")
+	}
+	return fragmentProfile, fragmentName, nil
+}
+
+// loadFragment renders and decodes the fragment file name of profile.
+func (c *Config) loadFragment(
+	profile, name string, decode positionDecoder) (any, map[string]position, error) {
+
+	dirPath := sourceLocation(c.source, profile)
+	content, err := c.source.Read(profile, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	rendered, lines, err := c.render(content)
+	if err != nil {
+		return nil, nil, &TemplateError{Dir: dirPath, File: name, Err: err}
+	}
+	values, positions, err := decode(rendered, c.tagFileReader(profile))
+	if err != nil {
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			parseErr = &ParseError{Err: err}
+		}
+		parseErr.Dir = dirPath
+		parseErr.File = name
+		return nil, nil, parseErr
+	}
+	if len(values) != 1 {
+		return nil, nil, &ParseError{Dir: dirPath, File: name,
+			Err: fmt.Errorf("a fragment must hold a single value, got %d", len(values))}
+	}
+	var valuePositions map[string]position
+	if positions != nil {
+		valuePositions = positions[0]
+		toSourceLines(valuePositions, lines)
+	}
+	return values[0], valuePositions, nil
+}
@@ -0,0 +1,89 @@
+package apconf
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInclude(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"logging/logging.yaml": `kind: Config
+metadata:
+  name: zap_logging_config
+spec:
+  cores:
+    console:
+      encoder: {$include: ../shared/zap_encoder.yaml}
+    json:
+      encoder:
+        $include: ../shared/zap_encoder.yaml
+        timeKey: ts
+      outputs: [{$include: fragments/output.yaml}]
+`,
+		"logging/fragments/output.yaml": "path: /var/log/{{ .proc_id }}.log\n",
+		"shared/zap_encoder.yaml": `timeKey: time
+levelKey: level
+caller: {$include: caller.yaml}
+`,
+		"shared/caller.yaml": "callerKey: caller\n",
+	})
+
+	cfg, err := LoadConfig(root, []string{"logging"}, map[string]any{"proc_id": 7}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	cores := cfg.config["zap_logging_config"].(map[string]any)["spec"].(map[string]any)["cores"].(map[string]any)
+	encoder := map[string]any{
+		"timeKey":  "time",
+		"levelKey": "level",
+		"caller":   map[string]any{"callerKey": "caller"},
+	}
+	if got := cores["console"].(map[string]any)["encoder"]; !reflect.DeepEqual(got, encoder) {
+		t.Errorf("Expected encoder %v, got %v", encoder, got)
+	}
+	encoder["timeKey"] = "ts"
+	if got := cores["json"].(map[string]any)["encoder"]; !reflect.DeepEqual(got, encoder) {
+		t.Errorf("Expected encoder %v, got %v", encoder, got)
+	}
+	outputs := []any{map[string]any{"path": "/var/log/7.log"}}
+	if got := cores["json"].(map[string]any)["outputs"]; !reflect.DeepEqual(got, outputs) {
+		t.Errorf("Expected outputs %v, got %v", outputs, got)
+	}
+
+	origins := cfg.Explain("zap_logging_config.spec.cores.console.encoder.caller.callerKey")
+	if len(origins) != 1 || origins[0].File != filepath.Join(root, "shared", "caller.yaml") || origins[0].Line != 1 {
+		t.Errorf("Expected the origin to be the included file, got %v", origins)
+	}
+	origins = cfg.Explain("zap_logging_config.spec.cores.json.encoder.timeKey")
+	if len(origins) != 1 || origins[0].File != filepath.Join(root, "logging", "logging.yaml") {
+		t.Errorf("Expected the origin to be the including file, got %v", origins)
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	cases := map[string]string{
+		"include cycle":     "a: {$include: ../shared/a.yaml}\n",
+		"profile directory": "a: {$include: ../../a.yaml}\n",
+		"cannot merge":      "a: {$include: ../shared/scalar.yaml, b: 1}\n",
+	}
+	for message, spec := range cases {
+		root := writeProfiles(t, map[string]string{
+			"base/crawl.yaml":    "kind: Config\nmetadata:\n  name: crawler_config\nspec:\n  " + spec,
+			"shared/a.yaml":      "{$include: b.yaml}\n",
+			"shared/b.yaml":      "{$include: a.yaml}\n",
+			"shared/scalar.yaml": "1\n",
+		})
+		_, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil)
+		var includeErr *IncludeError
+		if !errors.As(err, &includeErr) {
+			t.Errorf("%s: expected an IncludeError, got %v", message, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: unexpected error %v", message, err)
+		}
+	}
+}
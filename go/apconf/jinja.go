@@ -0,0 +1,1492 @@
+package apconf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The Jinja subset rendered in the JinjaTemplates mode, which renders
+// like jinja2.Template(content).render(templateParams) in Python:
+//
+//	{{ expr }}                  output, None renders as "None" and
+//	                            booleans as "True" and "False"
+//	{% if c %}{% elif c %}{% else %}{% endif %}
+//	{% for x in list %}{% else %}{% endfor %}
+//	{% for k, v in map.items() %}...{% endfor %}
+//	{% set x = expr %}
+//	{# comment #}
+//	{%- ... -%}, {{- ... -}}    whitespace control
+//
+// Expressions support literals ('a', "a", 1, 1.5, true, none and lists),
+// variables with attribute and item access (a.b, a['b'], a[0]), the
+// operators + - * / // % ~, comparisons, in, not in, and, or, not, the
+// tests "is defined", "is undefined" and "is none", the methods items(),
+// keys() and values() of maps, range(n) and the filters default (d),
+// lower, upper, capitalize, title, trim, join, length (count), replace,
+// int, float, string, first, last and sort. Inside a for loop, loop.index,
+// loop.index0, loop.first, loop.last and loop.length are defined. Maps are
+// iterated in the order of their keys. Undefined variables render as
+// empty strings, or fail like with jinja2.StrictUndefined in strict mode.
+// Reading an attribute or an item of an undefined variable fails in both
+// modes.
+
+// jinjaUndefined is the value of undefined variables.
+type jinjaUndefined struct {
+	name string
+}
+
+type jinjaNode interface {
+	render(ctx *jinjaContext, out *strings.Builder) error
+}
+
+type jinjaText struct {
+	text string
+	line int
+}
+
+type jinjaOutput struct {
+	expr jinjaExpr
+	line int
+}
+
+type jinjaBranch struct {
+	cond jinjaExpr
+	body []jinjaNode
+}
+
+type jinjaIf struct {
+	branches []jinjaBranch
+	elseBody []jinjaNode
+	line     int
+}
+
+type jinjaFor struct {
+	vars     []string
+	iter     jinjaExpr
+	body     []jinjaNode
+	elseBody []jinjaNode
+	line     int
+}
+
+type jinjaSet struct {
+	name string
+	expr jinjaExpr
+	line int
+}
+
+// jinjaContext holds the variables of a render, innermost scope last.
+type jinjaContext struct {
+	scopes []map[string]any
+	strict bool
+}
+
+// defined fails with *UndefinedParamError in strict mode if value is
+// undefined.
+func (ctx *jinjaContext) defined(value any) error {
+	if undefined, ok := value.(jinjaUndefined); ok && ctx.strict {
+		return &UndefinedParamError{Param: undefined.name}
+	}
+	return nil
+}
+
+// jinjaLineError locates err at line.
+func jinjaLineError(line int, err error) error {
+	var undefinedErr *UndefinedParamError
+	if errors.As(err, &undefinedErr) {
+		if undefinedErr.Line == 0 {
+			undefinedErr.Line = line
+		}
+		return err
+	}
+	return fmt.Errorf("line %d: %w", line, err)
+}
+
+func (ctx *jinjaContext) lookup(name string) any {
+	for i := len(ctx.scopes) - 1; i >= 0; i-- {
+		if value, ok := ctx.scopes[i][name]; ok {
+			return value
+		}
+	}
+	return jinjaUndefined{name: name}
+}
+
+// renderJinja renders content as a Jinja template with params. It also
+// returns the source line of each rendered line, see stripLineMarkers. If
+// strict is set, undefined params fail with *UndefinedParamError.
+func renderJinja(content []byte, params map[string]any, strict bool) ([]byte, []int, error) {
+	nodes, err := parseJinja(string(content))
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx := &jinjaContext{scopes: []map[string]any{params, make(map[string]any)}, strict: strict}
+	var out strings.Builder
+	if err := renderJinjaNodes(ctx, nodes, &out); err != nil {
+		return nil, nil, err
+	}
+	rendered, lines := stripLineMarkers([]byte(out.String()))
+	return rendered, lines, nil
+}
+
+func renderJinjaNodes(ctx *jinjaContext, nodes []jinjaNode, out *strings.Builder) error {
+	for _, node := range nodes {
+		if err := node.render(ctx, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *jinjaText) render(_ *jinjaContext, out *strings.Builder) error {
+	out.Write(markLines([]byte(n.text), n.line))
+	return nil
+}
+
+func (n *jinjaOutput) render(ctx *jinjaContext, out *strings.Builder) error {
+	value, err := n.expr.eval(ctx)
+	if err == nil {
+		err = ctx.defined(value)
+	}
+	if err != nil {
+		return jinjaLineError(n.line, err)
+	}
+	out.WriteString(pyString(value))
+	return nil
+}
+
+func (n *jinjaIf) render(ctx *jinjaContext, out *strings.Builder) error {
+	for _, branch := range n.branches {
+		cond, err := branch.cond.eval(ctx)
+		if err == nil {
+			err = ctx.defined(cond)
+		}
+		if err != nil {
+			return jinjaLineError(n.line, err)
+		}
+		if pyTruthy(cond) {
+			return renderJinjaNodes(ctx, branch.body, out)
+		}
+	}
+	return renderJinjaNodes(ctx, n.elseBody, out)
+}
+
+func (n *jinjaFor) render(ctx *jinjaContext, out *strings.Builder) error {
+	iter, err := n.iter.eval(ctx)
+	if err == nil {
+		err = ctx.defined(iter)
+	}
+	if err != nil {
+		return jinjaLineError(n.line, err)
+	}
+	items, err := pyIterate(iter)
+	if err != nil {
+		return jinjaLineError(n.line, err)
+	}
+	if len(items) == 0 {
+		return renderJinjaNodes(ctx, n.elseBody, out)
+	}
+	for i, item := range items {
+		scope := map[string]any{
+			"loop": map[string]any{
+				"index":  i + 1,
+				"index0": i,
+				"first":  i == 0,
+				"last":   i == len(items)-1,
+				"length": len(items),
+			},
+		}
+		if len(n.vars) == 1 {
+			scope[n.vars[0]] = item
+		} else {
+			values, ok := item.([]any)
+			if !ok || len(values) != len(n.vars) {
+				return fmt.Errorf("line %d: cannot unpack %s into %d variables",
+					n.line, pyRepr(item), len(n.vars))
+			}
+			for j, name := range n.vars {
+				scope[name] = values[j]
+			}
+		}
+		ctx.scopes = append(ctx.scopes, scope)
+		err := renderJinjaNodes(ctx, n.body, out)
+		ctx.scopes = ctx.scopes[:len(ctx.scopes)-1]
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *jinjaSet) render(ctx *jinjaContext, _ *strings.Builder) error {
+	value, err := n.expr.eval(ctx)
+	if err != nil {
+		return jinjaLineError(n.line, err)
+	}
+	ctx.scopes[len(ctx.scopes)-1][n.name] = value
+	return nil
+}
+
+// jinjaTag is a piece of a template: text, an output, a statement or a
+// comment.
+type jinjaTag struct {
+	kind byte // 't' for text, or the second character of the delimiter
+	text string
+	line int
+}
+
+// lexJinja splits a template into text and tags, applying whitespace
+// control. Like Jinja, it drops a single trailing newline.
+func lexJinja(src string) ([]jinjaTag, error) {
+	src = strings.TrimSuffix(src, "\n")
+	var tags []jinjaTag
+	line := 1
+	trimNext := false
+	for len(src) > 0 {
+		start := -1
+		for i := 0; i+1 < len(src); i++ {
+			if src[i] == '{' && (src[i+1] == '{' || src[i+1] == '%' || src[i+1] == '#') {
+				start = i
+				break
+			}
+		}
+		text := src
+		if start >= 0 {
+			text = src[:start]
+		}
+		textLine := line
+		if trimNext {
+			trimmed := strings.TrimLeftFunc(text, unicode.IsSpace)
+			textLine += strings.Count(text[:len(text)-len(trimmed)], "\n")
+			text = trimmed
+		}
+		if start < 0 {
+			tags = append(tags, jinjaTag{kind: 't', text: text, line: textLine})
+			break
+		}
+
+		kind := src[start+1]
+		body := src[start+2:]
+		if strings.HasPrefix(body, "-") {
+			text = strings.TrimRightFunc(text, unicode.IsSpace)
+			body = body[1:]
+		}
+		tags = append(tags, jinjaTag{kind: 't', text: text, line: textLine})
+		line += strings.Count(src[:start], "\n")
+
+		closing := map[byte]string{'{': "}}", '%': "%}", '#': "#}"}[kind]
+		end := findClosing(body, closing, kind != '#')
+		if end < 0 {
+			return nil, fmt.Errorf("line %d: unclosed %s", line, src[start:start+2])
+		}
+		inner := body[:end]
+		trimNext = strings.HasSuffix(inner, "-")
+		inner = strings.TrimSuffix(inner, "-")
+		if kind != '#' {
+			tags = append(tags, jinjaTag{kind: kind, text: strings.TrimSpace(inner), line: line})
+		}
+		consumed := len(src) - len(body) + end + len(closing)
+		line += strings.Count(src[start:consumed], "\n")
+		src = src[consumed:]
+	}
+	return tags, nil
+}
+
+// findClosing returns the index of closing in s, skipping string literals
+// if quoted is set.
+func findClosing(s, closing string, quoted bool) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == quote {
+				quote = 0
+			}
+		case quoted && (s[i] == '\'' || s[i] == '"'):
+			quote = s[i]
+		case strings.HasPrefix(s[i:], closing):
+			return i
+		}
+	}
+	return -1
+}
+
+type jinjaParser struct {
+	tags []jinjaTag
+	pos  int
+}
+
+func parseJinja(src string) ([]jinjaNode, error) {
+	tags, err := lexJinja(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &jinjaParser{tags: tags}
+	nodes, end, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	if end != nil {
+		return nil, fmt.Errorf("line %d: unexpected {%% %s %%}", end.line, end.text)
+	}
+	return nodes, nil
+}
+
+// parseBody parses nodes up to the end of the template or a statement
+// ending a block, e.g. endif or else, which it returns.
+func (p *jinjaParser) parseBody() ([]jinjaNode, *jinjaTag, error) {
+	var nodes []jinjaNode
+	for p.pos < len(p.tags) {
+		tag := p.tags[p.pos]
+		p.pos++
+		switch tag.kind {
+		case 't':
+			if tag.text != "" {
+				nodes = append(nodes, &jinjaText{text: tag.text, line: tag.line})
+			}
+		case '{':
+			expr, err := parseJinjaExpr(tag.text)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", tag.line, err)
+			}
+			nodes = append(nodes, &jinjaOutput{expr: expr, line: tag.line})
+		case '%':
+			keyword, rest, _ := strings.Cut(tag.text, " ")
+			rest = strings.TrimSpace(rest)
+			var node jinjaNode
+			var err error
+			switch keyword {
+			case "if":
+				node, err = p.parseIf(rest, tag.line)
+			case "for":
+				node, err = p.parseFor(rest, tag.line)
+			case "set":
+				node, err = parseSet(rest, tag.line)
+			case "elif", "else", "endif", "endfor":
+				return nodes, &tag, nil
+			default:
+				err = fmt.Errorf("line %d: unknown statement %q", tag.line, keyword)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil, nil
+}
+
+func (p *jinjaParser) parseIf(cond string, line int) (jinjaNode, error) {
+	node := &jinjaIf{line: line}
+	for {
+		expr, err := parseJinjaExpr(cond)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		body, end, err := p.parseBody()
+		if err != nil {
+			return nil, err
+		}
+		node.branches = append(node.branches, jinjaBranch{cond: expr, body: body})
+		if end == nil {
+			return nil, fmt.Errorf("line %d: missing {%% endif %%}", line)
+		}
+		keyword, rest, _ := strings.Cut(end.text, " ")
+		switch keyword {
+		case "elif":
+			cond, line = rest, end.line
+			continue
+		case "else":
+			node.elseBody, end, err = p.parseBody()
+			if err != nil {
+				return nil, err
+			}
+			if end == nil || end.text != "endif" {
+				return nil, fmt.Errorf("line %d: missing {%% endif %%}", line)
+			}
+			return node, nil
+		case "endif":
+			return node, nil
+		default:
+			return nil, fmt.Errorf("line %d: unexpected {%% %s %%}", end.line, end.text)
+		}
+	}
+}
+
+func (p *jinjaParser) parseFor(header string, line int) (jinjaNode, error) {
+	target, iter, ok := strings.Cut(header, " in ")
+	if !ok {
+		return nil, fmt.Errorf("line %d: expected {%% for x in items %%}", line)
+	}
+	node := &jinjaFor{line: line}
+	for _, name := range strings.Split(target, ",") {
+		name = strings.TrimSpace(name)
+		if !isJinjaName(name) {
+			return nil, fmt.Errorf("line %d: invalid loop variable %q", line, name)
+		}
+		node.vars = append(node.vars, name)
+	}
+	expr, err := parseJinjaExpr(iter)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", line, err)
+	}
+	node.iter = expr
+	body, end, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	node.body = body
+	if end != nil && end.text == "else" {
+		node.elseBody, end, err = p.parseBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if end == nil || end.text != "endfor" {
+		return nil, fmt.Errorf("line %d: missing {%% endfor %%}", line)
+	}
+	return node, nil
+}
+
+func parseSet(assignment string, line int) (jinjaNode, error) {
+	name, value, ok := strings.Cut(assignment, "=")
+	name = strings.TrimSpace(name)
+	if !ok || !isJinjaName(name) {
+		return nil, fmt.Errorf("line %d: expected {%% set name = value %%}", line)
+	}
+	expr, err := parseJinjaExpr(value)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", line, err)
+	}
+	return &jinjaSet{name: name, expr: expr, line: line}, nil
+}
+
+func isJinjaName(s string) bool {
+	if s == "" || unicode.IsDigit(rune(s[0])) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// Expressions
+
+type jinjaExpr interface {
+	eval(ctx *jinjaContext) (any, error)
+}
+
+type jinjaLiteral struct {
+	value any
+}
+
+type jinjaName struct {
+	name string
+}
+
+type jinjaList struct {
+	items []jinjaExpr
+}
+
+type jinjaGetItem struct {
+	obj jinjaExpr
+	key jinjaExpr
+}
+
+type jinjaCall struct {
+	obj    jinjaExpr // nil for global functions
+	method string
+	args   []jinjaExpr
+}
+
+type jinjaFilter struct {
+	value jinjaExpr
+	name  string
+	args  []jinjaExpr
+}
+
+type jinjaTest struct {
+	value  jinjaExpr
+	test   string
+	negate bool
+}
+
+type jinjaUnary struct {
+	op      string
+	operand jinjaExpr
+}
+
+type jinjaBinary struct {
+	op          string
+	left, right jinjaExpr
+}
+
+// jinjaOp returns the operator expr starts with, or "".
+func jinjaOp(expr string) string {
+	if len(expr) >= 2 {
+		switch op := expr[:2]; op {
+		case "==", "!=", "<=", ">=", "//":
+			return op
+		}
+	}
+	if expr != "" && strings.IndexByte("<>+-*/%~|.,()[]", expr[0]) >= 0 {
+		return expr[:1]
+	}
+	return ""
+}
+
+func lexJinjaExpr(expr string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			var value strings.Builder
+			end := i + 1
+			for ; end < len(expr) && expr[end] != c; end++ {
+				if expr[end] == '\\' && end+1 < len(expr) {
+					end++
+					switch expr[end] {
+					case 'n':
+						value.WriteByte('\n')
+					case 't':
+						value.WriteByte('\t')
+					default:
+						value.WriteByte(expr[end])
+					}
+					continue
+				}
+				value.WriteByte(expr[end])
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string in %q", expr)
+			}
+			tokens = append(tokens, exprToken{kind: "string", text: expr[i : end+1], value: value.String()})
+			i = end + 1
+		case c >= '0' && c <= '9':
+			end := i
+			for end < len(expr) && expr[end] >= '0' && expr[end] <= '9' {
+				end++
+			}
+			if end+1 < len(expr) && expr[end] == '.' && expr[end+1] >= '0' && expr[end+1] <= '9' {
+				end++
+				for end < len(expr) && expr[end] >= '0' && expr[end] <= '9' {
+					end++
+				}
+			}
+			text := expr[i:end]
+			var value any
+			if n, err := strconv.Atoi(text); err == nil {
+				value = n
+			} else if f, err := strconv.ParseFloat(text, 64); err == nil {
+				value = f
+			} else {
+				return nil, fmt.Errorf("invalid number %s", text)
+			}
+			tokens = append(tokens, exprToken{kind: "number", text: text, value: value})
+			i = end
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i
+			for end < len(expr) && (expr[end] == '_' || unicode.IsLetter(rune(expr[end])) ||
+				unicode.IsDigit(rune(expr[end]))) {
+				end++
+			}
+			tokens = append(tokens, exprToken{kind: "ident", text: expr[i:end]})
+			i = end
+		default:
+			op := jinjaOp(expr[i:])
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q in %q", c, expr)
+			}
+			tokens = append(tokens, exprToken{kind: "op", text: op})
+			i += len(op)
+		}
+	}
+	return append(tokens, exprToken{kind: "eof", text: "end of expression"}), nil
+}
+
+type jinjaExprParser struct {
+	exprParser
+}
+
+func parseJinjaExpr(expr string) (jinjaExpr, error) {
+	tokens, err := lexJinjaExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &jinjaExprParser{exprParser{tokens: tokens}}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != "eof" {
+		return nil, fmt.Errorf("unexpected %q in %q", tok.text, expr)
+	}
+	return node, nil
+}
+
+func (p *jinjaExprParser) isKeyword(keyword string) bool {
+	tok := p.peek()
+	return tok.kind == "ident" && tok.text == keyword
+}
+
+func (p *jinjaExprParser) expectOp(op string) error {
+	if !p.isOp(op) {
+		return fmt.Errorf("expected %s but got %q", op, p.peek().text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *jinjaExprParser) parseOr() (jinjaExpr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.isKeyword("or") {
+		p.next()
+		var right jinjaExpr
+		right, err = p.parseAnd()
+		left = &jinjaBinary{op: "or", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseAnd() (jinjaExpr, error) {
+	left, err := p.parseNot()
+	for err == nil && p.isKeyword("and") {
+		p.next()
+		var right jinjaExpr
+		right, err = p.parseNot()
+		left = &jinjaBinary{op: "and", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseNot() (jinjaExpr, error) {
+	if p.isKeyword("not") {
+		p.next()
+		operand, err := p.parseNot()
+		return &jinjaUnary{op: "not", operand: operand}, err
+	}
+	return p.parseComparison()
+}
+
+func (p *jinjaExprParser) parseComparison() (jinjaExpr, error) {
+	left, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch {
+		case p.isOp("==", "!=", "<", "<=", ">", ">="):
+			op = p.next().text
+		case p.isKeyword("in"):
+			p.next()
+			op = "in"
+		case p.isKeyword("not") && p.tokens[p.pos+1].text == "in":
+			p.pos += 2
+			op = "not in"
+		case p.isKeyword("is"):
+			p.next()
+			negate := p.isKeyword("not")
+			if negate {
+				p.next()
+			}
+			test := p.next()
+			if test.kind != "ident" {
+				return nil, fmt.Errorf("expected a test name but got %q", test.text)
+			}
+			left = &jinjaTest{value: left, test: test.text, negate: negate}
+			continue
+		default:
+			return left, nil
+		}
+		right, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		left = &jinjaBinary{op: op, left: left, right: right}
+	}
+}
+
+func (p *jinjaExprParser) parseConcat() (jinjaExpr, error) {
+	return p.parseBinary(p.parseAdditive, "~")
+}
+
+func (p *jinjaExprParser) parseAdditive() (jinjaExpr, error) {
+	return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *jinjaExprParser) parseMultiplicative() (jinjaExpr, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "//", "%")
+}
+
+func (p *jinjaExprParser) parseBinary(
+	operand func() (jinjaExpr, error), ops ...string) (jinjaExpr, error) {
+
+	left, err := operand()
+	for err == nil && p.isOp(ops...) {
+		op := p.next().text
+		var right jinjaExpr
+		right, err = operand()
+		left = &jinjaBinary{op: op, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *jinjaExprParser) parseUnary() (jinjaExpr, error) {
+	if p.isOp("-") {
+		p.next()
+		operand, err := p.parseUnary()
+		return &jinjaUnary{op: "-", operand: operand}, err
+	}
+	return p.parseFilters()
+}
+
+func (p *jinjaExprParser) parseFilters() (jinjaExpr, error) {
+	value, err := p.parsePostfix()
+	for err == nil && p.isOp("|") {
+		p.next()
+		name := p.next()
+		if name.kind != "ident" {
+			return nil, fmt.Errorf("expected a filter name but got %q", name.text)
+		}
+		var args []jinjaExpr
+		if p.isOp("(") {
+			p.next()
+			args, err = p.parseArgs()
+		}
+		value = &jinjaFilter{value: value, name: name.text, args: args}
+	}
+	return value, err
+}
+
+// parseArgs parses the arguments of a call after the opening parenthesis.
+func (p *jinjaExprParser) parseArgs() ([]jinjaExpr, error) {
+	var args []jinjaExpr
+	for !p.isOp(")") {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	return args, p.expectOp(")")
+}
+
+func (p *jinjaExprParser) parsePostfix() (jinjaExpr, error) {
+	value, err := p.parsePrimary()
+	for err == nil {
+		switch {
+		case p.isOp("."):
+			p.next()
+			attr := p.next()
+			switch {
+			case attr.kind == "number":
+				value = &jinjaGetItem{obj: value, key: &jinjaLiteral{value: attr.value}}
+			case attr.kind != "ident":
+				return nil, fmt.Errorf("expected an attribute name but got %q", attr.text)
+			case p.isOp("("):
+				p.next()
+				var args []jinjaExpr
+				args, err = p.parseArgs()
+				value = &jinjaCall{obj: value, method: attr.text, args: args}
+			default:
+				value = &jinjaGetItem{obj: value, key: &jinjaLiteral{value: attr.text}}
+			}
+		case p.isOp("["):
+			p.next()
+			var key jinjaExpr
+			if key, err = p.parseOr(); err == nil {
+				err = p.expectOp("]")
+			}
+			value = &jinjaGetItem{obj: value, key: key}
+		default:
+			return value, nil
+		}
+	}
+	return nil, err
+}
+
+func (p *jinjaExprParser) parsePrimary() (jinjaExpr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case "string", "number":
+		return &jinjaLiteral{value: tok.value}, nil
+	case "ident":
+		switch tok.text {
+		case "true", "True":
+			return &jinjaLiteral{value: true}, nil
+		case "false", "False":
+			return &jinjaLiteral{value: false}, nil
+		case "none", "None":
+			return &jinjaLiteral{value: nil}, nil
+		}
+		if p.isOp("(") {
+			p.next()
+			args, err := p.parseArgs()
+			return &jinjaCall{method: tok.text, args: args}, err
+		}
+		return &jinjaName{name: tok.text}, nil
+	case "op":
+		switch tok.text {
+		case "(":
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expectOp(")")
+		case "[":
+			var items []jinjaExpr
+			for !p.isOp("]") {
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			return &jinjaList{items: items}, p.expectOp("]")
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q", tok.text)
+}
+
+func (e *jinjaLiteral) eval(*jinjaContext) (any, error) {
+	return e.value, nil
+}
+
+func (e *jinjaName) eval(ctx *jinjaContext) (any, error) {
+	return ctx.lookup(e.name), nil
+}
+
+func (e *jinjaList) eval(ctx *jinjaContext) (any, error) {
+	items := make([]any, len(e.items))
+	for i, item := range e.items {
+		value, err := item.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = value
+	}
+	return items, nil
+}
+
+func (e *jinjaGetItem) eval(ctx *jinjaContext) (any, error) {
+	obj, err := e.obj.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, err := e.key.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s[%s]", jinjaExprName(e.obj), pyRepr(key))
+	if s, ok := key.(string); ok && isJinjaName(s) {
+		name = jinjaExprName(e.obj) + "." + s
+	}
+	if err := ctx.defined(obj); err != nil {
+		return nil, err
+	}
+	switch o := obj.(type) {
+	case jinjaUndefined:
+		// Like in Jinja, only the last name of a path may be undefined.
+		return nil, &UndefinedParamError{Param: o.name}
+	case map[string]any:
+		if value, ok := o[fmt.Sprint(key)]; ok {
+			return value, nil
+		}
+	case []any:
+		if index, ok := key.(int); ok {
+			if index < 0 {
+				index += len(o)
+			}
+			if index >= 0 && index < len(o) {
+				return o[index], nil
+			}
+		}
+	}
+	return jinjaUndefined{name: name}, nil
+}
+
+// jinjaExprName describes the variable an expression reads, for the
+// names of undefined values.
+func jinjaExprName(e jinjaExpr) string {
+	switch v := e.(type) {
+	case *jinjaName:
+		return v.name
+	case *jinjaGetItem:
+		if key, ok := v.key.(*jinjaLiteral); ok {
+			if s, ok := key.value.(string); ok && isJinjaName(s) {
+				return jinjaExprName(v.obj) + "." + s
+			}
+			return fmt.Sprintf("%s[%s]", jinjaExprName(v.obj), pyRepr(key.value))
+		}
+	}
+	return "expression"
+}
+
+func (e *jinjaCall) eval(ctx *jinjaContext) (any, error) {
+	args := make([]any, len(e.args))
+	for i, arg := range e.args {
+		value, err := arg.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	if e.obj == nil {
+		if e.method != "range" || len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("unknown function %s with %d arguments", e.method, len(args))
+		}
+		start, stop := 0, args[0]
+		if len(args) == 2 {
+			start, _ = ToInt(args[0])
+			stop = args[1]
+		}
+		end, err := ToInt(stop)
+		if err != nil {
+			return nil, fmt.Errorf("range: %w", err)
+		}
+		var items []any
+		for i := start; i < end; i++ {
+			items = append(items, i)
+		}
+		return items, nil
+	}
+
+	obj, err := e.obj.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := obj.(map[string]any)
+	if !ok || len(args) != 0 || (e.method != "items" && e.method != "keys" && e.method != "values") {
+		return nil, fmt.Errorf("unknown method %s of %s", e.method, pyRepr(obj))
+	}
+	keys := sortedKeys(m)
+	items := make([]any, len(keys))
+	for i, key := range keys {
+		switch e.method {
+		case "items":
+			items[i] = []any{key, m[key]}
+		case "keys":
+			items[i] = key
+		case "values":
+			items[i] = m[key]
+		}
+	}
+	return items, nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (e *jinjaTest) eval(ctx *jinjaContext) (any, error) {
+	value, err := e.value.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, undefined := value.(jinjaUndefined)
+	var result bool
+	switch e.test {
+	case "defined":
+		result = !undefined
+	case "undefined":
+		result = undefined
+	case "none":
+		result = value == nil
+	default:
+		return nil, fmt.Errorf("unknown test %s", e.test)
+	}
+	return result != e.negate, nil
+}
+
+func (e *jinjaUnary) eval(ctx *jinjaContext) (any, error) {
+	value, err := e.operand.eval(ctx)
+	if err == nil {
+		err = ctx.defined(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e.op == "not" {
+		return !pyTruthy(value), nil
+	}
+	switch v := value.(type) {
+	case int:
+		return -v, nil
+	case float64:
+		return -v, nil
+	}
+	return nil, fmt.Errorf("bad operand for unary -: %s", pyRepr(value))
+}
+
+func (e *jinjaBinary) eval(ctx *jinjaContext) (any, error) {
+	left, err := e.left.eval(ctx)
+	if err == nil {
+		err = ctx.defined(left)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "and":
+		if !pyTruthy(left) {
+			return left, nil
+		}
+		return e.right.eval(ctx)
+	case "or":
+		if pyTruthy(left) {
+			return left, nil
+		}
+		return e.right.eval(ctx)
+	}
+	right, err := e.right.eval(ctx)
+	if err == nil {
+		err = ctx.defined(right)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "~":
+		return pyString(left) + pyString(right), nil
+	case "==":
+		return pyEqual(left, right), nil
+	case "!=":
+		return !pyEqual(left, right), nil
+	case "in", "not in":
+		contained, err := pyContains(right, left)
+		return contained == (e.op == "in"), err
+	case "<", "<=", ">", ">=":
+		cmp, err := compareValues(left, right)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]bool{"<": cmp < 0, "<=": cmp <= 0, ">": cmp > 0, ">=": cmp >= 0}[e.op], nil
+	}
+	return pyArithmetic(e.op, left, right)
+}
+
+func pyArithmetic(op string, left, right any) (any, error) {
+	if op == "+" {
+		if l, ok := left.(string); ok {
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		}
+		if l, ok := left.([]any); ok {
+			if r, ok := right.([]any); ok {
+				return append(append([]any{}, l...), r...), nil
+			}
+		}
+	}
+	l, lok := left.(int)
+	r, rok := right.(int)
+	if lok && rok && op != "/" {
+		switch op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		}
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		q := l / r
+		if (l%r != 0) && ((l < 0) != (r < 0)) {
+			q--
+		}
+		if op == "//" {
+			return q, nil
+		}
+		return l - q*r, nil
+	}
+	lf, lok := toFloat(left)
+	rf, rok := toFloat(right)
+	if !lok || !rok {
+		return nil, fmt.Errorf("unsupported operands for %s: %s and %s", op, pyRepr(left), pyRepr(right))
+	}
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	}
+	if rf == 0 {
+		return nil, errors.New("division by zero")
+	}
+	switch op {
+	case "/":
+		return lf / rf, nil
+	case "//":
+		return math.Floor(lf / rf), nil
+	default:
+		return lf - math.Floor(lf/rf)*rf, nil
+	}
+}
+
+func (e *jinjaFilter) eval(ctx *jinjaContext) (any, error) {
+	value, err := e.value.eval(ctx)
+	if err == nil && e.name != "default" && e.name != "d" {
+		err = ctx.defined(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	args := make([]any, len(e.args))
+	for i, arg := range e.args {
+		if args[i], err = arg.eval(ctx); err != nil {
+			return nil, err
+		}
+	}
+	filter, ok := jinjaFilterFunc(e.name)
+	if !ok {
+		return nil, fmt.Errorf("unknown filter %s", e.name)
+	}
+	result, err := filter(value, args)
+	if err != nil {
+		return nil, fmt.Errorf("filter %s: %w", e.name, err)
+	}
+	return result, nil
+}
+
+func argOr(args []any, i int, def any) any {
+	if i < len(args) {
+		return args[i]
+	}
+	return def
+}
+
+// jinjaFilterFunc returns the filter named name, called with the value
+// it filters and its arguments.
+func jinjaFilterFunc(name string) (func(value any, args []any) (any, error), bool) {
+	switch name {
+	case "default", "d":
+		return filterDefault, true
+	case "lower":
+		return stringFilter(strings.ToLower), true
+	case "upper":
+		return stringFilter(strings.ToUpper), true
+	case "trim":
+		return stringFilter(strings.TrimSpace), true
+	case "capitalize":
+		return stringFilter(pyCapitalize), true
+	case "title":
+		return stringFilter(pyTitle), true
+	case "join":
+		return filterJoin, true
+	case "length", "count":
+		return filterLength, true
+	case "replace":
+		return filterReplace, true
+	case "int":
+		return filterInt, true
+	case "float":
+		return filterFloat, true
+	case "string":
+		return stringFilter(func(s string) string { return s }), true
+	case "first":
+		return filterFirst, true
+	case "last":
+		return filterLast, true
+	case "sort":
+		return filterSort, true
+	}
+	return nil, false
+}
+
+func stringFilter(fn func(string) string) func(any, []any) (any, error) {
+	return func(value any, _ []any) (any, error) {
+		return fn(pyString(value)), nil
+	}
+}
+
+func filterDefault(value any, args []any) (any, error) {
+	_, undefined := value.(jinjaUndefined)
+	if undefined || (pyTruthy(argOr(args, 1, false)) && !pyTruthy(value)) {
+		return argOr(args, 0, ""), nil
+	}
+	return value, nil
+}
+
+func filterJoin(value any, args []any) (any, error) {
+	items, err := pyIterate(value)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = pyString(item)
+	}
+	return strings.Join(parts, pyString(argOr(args, 0, ""))), nil
+}
+
+func filterLength(value any, _ []any) (any, error) {
+	if s, ok := value.(string); ok {
+		return len([]rune(s)), nil
+	}
+	items, err := pyIterate(value)
+	return len(items), err
+}
+
+func filterReplace(value any, args []any) (any, error) {
+	if len(args) != 2 {
+		return nil, errors.New("expected the old and the new string")
+	}
+	return strings.ReplaceAll(pyString(value), pyString(args[0]), pyString(args[1])), nil
+}
+
+func filterInt(value any, args []any) (any, error) {
+	if f, ok := toFloat(value); ok {
+		return int(f), nil
+	}
+	if s, ok := value.(string); ok {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+			return int(f), nil
+		}
+	}
+	return argOr(args, 0, 0), nil
+}
+
+func filterFloat(value any, args []any) (any, error) {
+	if f, ok := toFloat(value); ok {
+		return f, nil
+	}
+	if s, ok := value.(string); ok {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+			return f, nil
+		}
+	}
+	return argOr(args, 0, 0.0), nil
+}
+
+func filterFirst(value any, _ []any) (any, error) {
+	items, err := pyIterate(value)
+	if err != nil || len(items) == 0 {
+		return jinjaUndefined{name: "first"}, err
+	}
+	return items[0], nil
+}
+
+func filterLast(value any, _ []any) (any, error) {
+	items, err := pyIterate(value)
+	if err != nil || len(items) == 0 {
+		return jinjaUndefined{name: "last"}, err
+	}
+	return items[len(items)-1], nil
+}
+
+func filterSort(value any, args []any) (any, error) {
+	items, err := pyIterate(value)
+	if err != nil {
+		return nil, err
+	}
+	sorted := append([]any{}, items...)
+	reverse := pyTruthy(argOr(args, 0, false))
+	sort.SliceStable(sorted, func(i, j int) bool {
+		cmp, _ := compareValues(sorted[i], sorted[j])
+		return (cmp < 0) != reverse && cmp != 0
+	})
+	return sorted, nil
+}
+
+// Python semantics
+
+func pyTruthy(value any) bool {
+	switch v := value.(type) {
+	case nil, jinjaUndefined:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	}
+	if f, ok := toFloat(value); ok {
+		return f != 0
+	}
+	return true
+}
+
+func pyEqual(left, right any) bool {
+	if _, ok := toFloat(left); ok {
+		return valuesEqual(left, right)
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+func pyContains(container, item any) (bool, error) {
+	switch c := container.(type) {
+	case string:
+		return strings.Contains(c, pyString(item)), nil
+	case []any:
+		for _, candidate := range c {
+			if pyEqual(candidate, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]any:
+		key, ok := item.(string)
+		if !ok {
+			return false, nil
+		}
+		_, exists := c[key]
+		return exists, nil
+	}
+	return false, fmt.Errorf("argument of type %T is not iterable", container)
+}
+
+// pyIterate returns the items of a list, the keys of a map in order or
+// the characters of a string. Undefined values have no items.
+func pyIterate(value any) ([]any, error) {
+	switch v := value.(type) {
+	case jinjaUndefined:
+		return nil, nil
+	case []any:
+		return v, nil
+	case map[string]any:
+		keys := sortedKeys(v)
+		items := make([]any, len(keys))
+		for i, key := range keys {
+			items[i] = key
+		}
+		return items, nil
+	case string:
+		items := make([]any, 0, len(v))
+		for _, r := range v {
+			items = append(items, string(r))
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("%s is not iterable", pyRepr(value))
+}
+
+// pyString formats a value like Python's str.
+func pyString(value any) string {
+	switch v := value.(type) {
+	case jinjaUndefined:
+		return ""
+	case string:
+		return v
+	}
+	return pyRepr(value)
+}
+
+// pyCapitalize upper-cases the first character of s and lower-cases the
+// others, like Python's str.capitalize.
+func pyCapitalize(s string) string {
+	first, size := utf8.DecodeRuneInString(s)
+	if size == 0 {
+		return s
+	}
+	return string(unicode.ToUpper(first)) + strings.ToLower(s[size:])
+}
+
+// pyTitle capitalizes the words of s, which start after whitespace, '-'
+// or an opening bracket, like Jinja's title filter. Unlike Python's
+// str.title, "it's" becomes "It's".
+func pyTitle(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) || strings.ContainsRune("-({[<", runes[i-1]) {
+			runes[i] = unicode.ToUpper(r)
+		} else {
+			runes[i] = unicode.ToLower(r)
+		}
+	}
+	return string(runes)
+}
+
+// pyRepr formats a value like Python's repr.
+func pyRepr(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "None"
+	case jinjaUndefined:
+		return "Undefined"
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case string:
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`, "\n", `\n`).Replace(v) + "'"
+	case float64:
+		return pyFloat(v)
+	case float32:
+		return pyFloat(float64(v))
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = pyRepr(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]any:
+		keys := sortedKeys(v)
+		items := make([]string, len(keys))
+		for i, key := range keys {
+			items[i] = pyRepr(key) + ": " + pyRepr(v[key])
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+	return fmt.Sprint(value)
+}
+
+func pyFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	if abs := math.Abs(f); abs != 0 && (abs >= 1e16 || abs < 1e-4) {
+		s := strconv.FormatFloat(f, 'e', -1, 64)
+		mantissa, exponent, _ := strings.Cut(s, "e")
+		sign, digits := exponent[:1], strings.TrimLeft(exponent[1:], "0")
+		if len(digits) < 2 {
+			digits = "0" + digits
+		}
+		return mantissa + "e" + sign + digits
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
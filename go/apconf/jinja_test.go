@@ -0,0 +1,119 @@
+package apconf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// nolint: funlen
+func TestRenderJinja(t *testing.T) {
+	params := map[string]any{
+		"name":    "Crawler",
+		"workers": 4,
+		"ratio":   0.5,
+		"debug":   false,
+		"hosts":   []any{"a", "b"},
+		"db":      map[string]any{"host": "localhost", "port": 5432},
+		"nothing": nil,
+	}
+	cases := []struct {
+		template string
+		expected string
+	}{
+		{"{{ name }}", "Crawler"},
+		{"{{ missing }}", ""},
+		{"{{ missing | default('a') }}", "a"},
+		{"{{ nothing | default('a') }}", "None"},
+		{"{{ '' | default('a', true) }}", "a"},
+		{"{{ name | lower }} {{ name | upper }}", "crawler CRAWLER"},
+		{"{{ hosts | join(',') }} {{ hosts | length }}", "a,b 2"},
+		{"{{ db.host }}:{{ db['port'] }} {{ hosts[-1] }} {{ hosts.0 }}", "localhost:5432 b a"},
+		{"{{ debug }} {{ nothing }} {{ ratio }} {{ hosts }}", "False None 0.5 ['a', 'b']"},
+		{"{{ workers * 2 + 1 }} {{ workers / 8 }} {{ 7 // 2 }} {{ -7 % 3 }}", "9 0.5 3 2"},
+		{"{{ name ~ '-' ~ workers }}", "Crawler-4"},
+		{"{{ 'a' in hosts }} {{ 'c' not in hosts }} {{ 'host' in db }}", "True True True"},
+		{"{{ missing is defined }} {{ db is defined }} {{ nothing is none }}", "False True True"},
+		{"{{ name | replace('C', 'c') | title }}", "Crawler"},
+		{"{{ '12' | int + 1 }} {{ hosts | first }}{{ hosts | last }}", "13 ab"},
+		{"{% if debug %}debug{% elif workers > 2 and not debug %}many{% else %}few{% endif %}", "many"},
+		{"{% for h in hosts %}{{ loop.index }}={{ h }}{% if not loop.last %},{% endif %}{% endfor %}", "1=a,2=b"},
+		{"{% for k, v in db.items() %}{{ k }}={{ v }} {% endfor %}", "host=localhost port=5432 "},
+		{"{% for h in missing %}{{ h }}{% else %}none{% endfor %}", "none"},
+		{"{% for i in range(3) %}{{ i }}{% endfor %}", "012"},
+		{"{% set port = db.port + 1 %}{{ port }}", "5433"},
+		{"{% for h in hosts %}{% set x = h %}{% endfor %}[{{ x }}]", "[]"},
+		{"a:\n  {%- if true %}\n  b: 1\n  {%- endif %}\n", "a:\n  b: 1"},
+		{"{# comment #}a {{- ' b' -}} \n c", "a bc"},
+		{"{{ '}}' }}", "}}"},
+		{"{{ [1, 'a', [true, none]] }} {{ [] | length }} {{ [3, 1, 2] | sort | join('') }}",
+			"[1, 'a', [True, None]] 0 123"},
+		{"{% for c in 'abc' %}{{ c }}.{% endfor %} {{ 'été' | length }} {{ 'abc' | last }}",
+			"a.b.c. 3 c"},
+		{"{{ 1.0 }} {{ 2.5 * 2 }} {{ 7 / 2 }} {{ '3' | float }}", "1.0 5.0 3.5 3.0"},
+		{"{{ 100000000 * 100000000.0 }} {{ 0.00001 * 1 }}", "1e+16 1e-05"},
+		{"{{ 'école' | capitalize }} {{ \"it's a half-time (draw)\" | title }}",
+			"École It's A Half-Time (Draw)"},
+		{"{{ db.missing }}{{ db.missing | default('a') }} {{ hosts[5] is defined }}", "a False"},
+	}
+	for _, tc := range cases {
+		rendered, _, err := renderJinja([]byte(tc.template), params, false)
+		if err != nil {
+			t.Errorf("Failed to render %q: %v", tc.template, err)
+			continue
+		}
+		if string(rendered) != tc.expected {
+			t.Errorf("Expected %q to render %q, got %q", tc.template, tc.expected, rendered)
+		}
+	}
+
+	for _, invalid := range []string{
+		"{{ name",
+		"{% if debug %}a",
+		"{% for h in hosts %}a{% endif %}",
+		"{% while true %}{% endwhile %}",
+		"{{ name | nosuchfilter }}",
+		"{{ workers / 0 }}",
+		"line 1\n{{ name + }}",
+		"{{ missing.attr }}",
+		"{{ missing['key'] | default('a') }}",
+		"{{ db.missing.attr }}",
+		"{% if missing.attr is defined %}{% endif %}",
+	} {
+		_, _, err := renderJinja([]byte(invalid), params, false)
+		if err == nil {
+			t.Errorf("Expected %q to fail", invalid)
+		}
+	}
+	_, _, err := renderJinja([]byte("a\nb\n{{ name + }}"), params, false)
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected the error to report line 3, got %v", err)
+	}
+}
+
+func TestJinjaTemplates(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  num_workers: {{ num_workers | default(1) }}
+  debug: {{ debug }}
+  hosts:
+  {%- for host in hosts %}
+    - {{ host | lower }}
+  {%- endfor %}
+`,
+	})
+	cfg, err := LoadConfig(root, []string{"base"}, map[string]any{
+		"debug": true,
+		"hosts": []any{"A", "B"},
+	}, nil, nil, nil, WithTemplateMode(JinjaTemplates))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	expected := map[string]any{"num_workers": 1, "debug": true, "hosts": []any{"a", "b"}}
+	if spec := cfg.config["crawler_config"].(map[string]any)["spec"]; !reflect.DeepEqual(spec, expected) {
+		t.Errorf("Expected spec %v, got %v", expected, spec)
+	}
+}
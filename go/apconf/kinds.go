@@ -0,0 +1,126 @@
+package apconf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// KindHandler is the pipeline for the documents of a kind other than
+// Config. Validators and deployers receive the documents of the kind
+// keyed by metadata.name, like the Config pipeline does.
+type KindHandler struct {
+	// Decode converts a document into a typed value, see Config.Decoded.
+	// Optional.
+	Decode     func(doc map[string]any) (any, error)
+	Validators []func(map[string]any, map[string]any, ConfigDiffResult) bool
+	Deployers  []func(map[string]any, map[string]any, ConfigDiffResult) error
+}
+
+type kindPipeline struct {
+	kind    string
+	handler KindHandler
+}
+
+// WithKind routes the documents of kind to handler. Documents of kinds
+// without a handler are loaded but not processed, see Config.Documents.
+func WithKind(kind string, handler KindHandler) Option {
+	return func(c *Config) {
+		c.kinds = append(c.kinds, kindPipeline{kind: kind, handler: handler})
+	}
+}
+
+// Documents returns a copy of the documents of kind keyed by
+// metadata.name.
+func (c *Config) Documents(kind string) map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if kind == "Config" {
+		return deepClone(c.config)
+	}
+	docs, _ := c.documents[kind].(map[string]any)
+	return deepClone(docs)
+}
+
+// Decoded returns the value the Decode function of the kind's handler
+// produced for the named document.
+func (c *Config) Decoded(kind, name string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.decoded[kind][name]
+	return value, ok
+}
+
+// kindsUpdate holds the checked documents of the registered kinds, see
+// checkKinds.
+type kindsUpdate struct {
+	decoded map[string]map[string]any
+	diffs   []ConfigDiffResult
+}
+
+// checkKinds decodes and validates the documents in finalDict with the
+// pipelines of the registered kinds.
+func (c *Config) checkKinds(finalDict map[string]any) (kindsUpdate, error) {
+	update := kindsUpdate{
+		decoded: make(map[string]map[string]any),
+		diffs:   make([]ConfigDiffResult, len(c.kinds)),
+	}
+	for i, pipeline := range c.kinds {
+		newDocs, _ := finalDict[pipeline.kind].(map[string]any)
+		if newDocs == nil {
+			newDocs = make(map[string]any)
+			finalDict[pipeline.kind] = newDocs
+		}
+		oldDocs, _ := c.documents[pipeline.kind].(map[string]any)
+		if oldDocs == nil {
+			oldDocs = make(map[string]any)
+		}
+		update.diffs[i] = ConfigDiff(newDocs, oldDocs)
+
+		values, err := decodeKind(pipeline, newDocs)
+		if err != nil {
+			return update, err
+		}
+		update.decoded[pipeline.kind] = values
+
+		for _, validator := range pipeline.handler.Validators {
+			if !validator(newDocs, oldDocs, update.diffs[i]) {
+				return update, fmt.Errorf("%s documents %v failed validation", pipeline.kind, newDocs)
+			}
+		}
+	}
+	return update, nil
+}
+
+// deployKinds runs the deployers of the registered kinds on the documents
+// in finalDict, checked by checkKinds.
+func (c *Config) deployKinds(finalDict map[string]any, update kindsUpdate) []error {
+	var errs []error
+	for i, pipeline := range c.kinds {
+		newDocs := finalDict[pipeline.kind].(map[string]any)
+		oldDocs, _ := c.documents[pipeline.kind].(map[string]any)
+		for _, deployer := range pipeline.handler.Deployers {
+			if err := deployer(newDocs, oldDocs, update.diffs[i]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+func decodeKind(pipeline kindPipeline, docs map[string]any) (map[string]any, error) {
+	values := make(map[string]any)
+	if pipeline.handler.Decode == nil {
+		return values, nil
+	}
+	var errs []error
+	for name, doc := range docs {
+		docMap, _ := doc.(map[string]any)
+		value, err := pipeline.handler.Decode(docMap)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to decode %s %s: %w", pipeline.kind, name, err))
+			continue
+		}
+		values[name] = value
+	}
+	return values, errors.Join(errs...)
+}
@@ -0,0 +1,140 @@
+package apconf
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+type featureFlag struct {
+	Enabled bool
+	Rollout int
+}
+
+// nolint: funlen
+func TestKinds(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/flags.yaml": `kind: FeatureFlag
+metadata:
+  name: new_parser
+spec:
+  enabled: true
+  rollout: 25
+---
+kind: Schema
+metadata:
+  name: crawl_result
+spec:
+  fields: [url, status]
+`,
+	})
+	var deployed map[string]any
+	flagHandler := KindHandler{
+		Decode: func(doc msa) (any, error) {
+			spec, _ := doc["spec"].(msa)
+			enabled, ok := spec["enabled"].(bool)
+			if !ok {
+				return nil, errors.New("spec.enabled must be a bool")
+			}
+			rollout, err := ToInt(spec["rollout"])
+			if err != nil {
+				return nil, err
+			}
+			return featureFlag{Enabled: enabled, Rollout: rollout}, nil
+		},
+		Deployers: []func(msa, msa, ConfigDiffResult) error{
+			func(newDocs msa, _ msa, _ ConfigDiffResult) error {
+				deployed = newDocs
+				return nil
+			},
+		},
+	}
+
+	cfg, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil,
+		WithKind("FeatureFlag", flagHandler))
+	if err != nil {
+		t.Fatalf("Failed to load config without Config documents: %v", err)
+	}
+	if len(cfg.config) != 0 {
+		t.Errorf("Expected an empty Config, got %v", cfg.config)
+	}
+
+	t.Run("typed_documents", func(t *testing.T) {
+		flag, ok := cfg.Decoded("FeatureFlag", "new_parser")
+		if !ok || flag != (featureFlag{Enabled: true, Rollout: 25}) {
+			t.Errorf("Expected decoded feature flag, got %v", flag)
+		}
+		if _, ok := deployed["new_parser"]; !ok {
+			t.Errorf("Expected the FeatureFlag deployer to receive new_parser, got %v", deployed)
+		}
+	})
+
+	t.Run("unhandled_kind", func(t *testing.T) {
+		schemas := cfg.Documents("Schema")
+		if _, ok := schemas["crawl_result"]; !ok {
+			t.Errorf("Expected Schema documents to be kept, got %v", schemas)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		rejecting := flagHandler
+		rejecting.Validators = []func(msa, msa, ConfigDiffResult) bool{
+			func(msa, msa, ConfigDiffResult) bool { return false },
+		}
+		if _, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil,
+			WithKind("FeatureFlag", rejecting)); err == nil {
+			t.Errorf("Expected validation to fail")
+		}
+	})
+
+	t.Run("rejected_kind_deploys_nothing", func(t *testing.T) {
+		root := writeProfiles(t, map[string]string{
+			"base/app.yaml": "kind: Config\nmetadata:\n  name: app\nspec:\n  rollout: 10\n" +
+				"---\nkind: FeatureFlag\nmetadata:\n  name: new_parser\nspec:\n  enabled: true\n  rollout: 10\n",
+		})
+		configDeploys := 0
+		deployers := []func(msa, msa, ConfigDiffResult) error{
+			func(msa, msa, ConfigDiffResult) error {
+				configDeploys++
+				return nil
+			},
+		}
+		limited := flagHandler
+		limited.Validators = []func(msa, msa, ConfigDiffResult) bool{
+			func(newDocs msa, _ msa, _ ConfigDiffResult) bool {
+				rollout, _ := ToInt(newDocs["new_parser"].(msa)["spec"].(msa)["rollout"])
+				return rollout <= 50
+			},
+		}
+		cfg, err := LoadConfig(root, []string{"base"}, nil, nil, deployers, nil, WithKind("FeatureFlag", limited))
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+
+		writeFile(t, filepath.Join(root, "base", "app.yaml"),
+			"kind: Config\nmetadata:\n  name: app\nspec:\n  rollout: 90\n"+
+				"---\nkind: FeatureFlag\nmetadata:\n  name: new_parser\nspec:\n  enabled: true\n  rollout: 90\n")
+		if err := cfg.Reload(); err == nil {
+			t.Fatalf("Expected the FeatureFlag validator to reject the reload")
+		}
+		if configDeploys != 1 {
+			t.Errorf("Expected the rejected Config not to be deployed, got %d deploys", configDeploys)
+		}
+		if rollout := cfg.Documents("Config")["app"].(msa)["spec"].(msa)["rollout"]; rollout != 10 {
+			t.Errorf("Expected the applied Config to be kept, got rollout %v", rollout)
+		}
+		if flag, _ := cfg.Decoded("FeatureFlag", "new_parser"); flag != (featureFlag{Enabled: true, Rollout: 10}) {
+			t.Errorf("Expected the applied flag to be kept, got %v", flag)
+		}
+	})
+
+	t.Run("decode_error", func(t *testing.T) {
+		root := writeProfiles(t, map[string]string{
+			"base/flags.yaml": "kind: FeatureFlag\nmetadata:\n  name: broken\nspec:\n  enabled: maybe\n",
+		})
+		if _, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil,
+			WithKind("FeatureFlag", flagHandler)); err == nil {
+			t.Errorf("Expected decoding to fail")
+		}
+	})
+}
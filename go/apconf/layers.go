@@ -0,0 +1,305 @@
+package apconf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Names of the layers a Config is computed from. From lowest to highest
+// precedence the stack holds LayerDefaults if WithDefaults is given, one
+// ProfileLayer per profile, LayerEnv if WithEnvOverlay is given, LayerFlags
+// if WithSetFlags is given and LayerRuntime.
+const (
+	LayerDefaults = "defaults"
+	LayerEnv      = "env"
+	LayerFlags    = "flags"
+	LayerRuntime  = "runtime"
+
+	profileLayerPrefix = "profile:"
+)
+
+// ProfileLayer returns the name of the layer holding a profile.
+func ProfileLayer(profile string) string {
+	return profileLayerPrefix + profile
+}
+
+type layerKind int
+
+const (
+	// valuesLayer holds config values deep-merged onto the layers below.
+	valuesLayer layerKind = iota
+	// documentsLayer holds the documents of a profile.
+	documentsLayer
+	// overlayLayer computes its values from the layers below.
+	overlayLayer
+)
+
+type layer struct {
+	name    string
+	kind    layerKind
+	profile string
+	docs    []document
+	overlay func(config map[string]any, overrides map[string]any) error
+	// values holds the config values of a values layer, or the values
+	// set by an overlay layer the last time the config was computed.
+	values map[string]any
+}
+
+// mergeInto merges the layer into finalDict and records the values it
+// sets in prov.
+func (l *layer) mergeInto(finalDict map[string]any, prov provenance) error {
+	origin := func(string) Origin { return Origin{Layer: l.name} }
+	switch l.kind {
+	case documentsLayer:
+		for _, doc := range l.docs {
+			prov.recordDocument(l.name, doc)
+		}
+		return mergeDocuments(l.docs, finalDict)
+	case overlayLayer:
+		config, ok := finalDict["Config"].(map[string]any)
+		if !ok {
+			config = make(map[string]any)
+			finalDict["Config"] = config
+		}
+		l.values = make(map[string]any)
+		if err := l.overlay(config, l.values); err != nil {
+			return err
+		}
+		prov.record("", l.values, origin)
+		return nil
+	default:
+		if len(l.values) == 0 {
+			return nil
+		}
+		base, _ := finalDict["Config"].(map[string]any)
+		merged, err := mergeMaps(base, l.values)
+		if err != nil {
+			return err
+		}
+		finalDict["Config"] = merged
+		prov.record("", l.values, origin)
+		return nil
+	}
+}
+
+func (c *Config) buildLayers(defaults map[string]any) {
+	if defaults != nil {
+		c.layers = append(c.layers, &layer{name: LayerDefaults, values: deepClone(defaults)})
+	}
+	for _, profile := range c.configBasenames {
+		c.layers = append(c.layers, &layer{
+			name:    ProfileLayer(profile),
+			kind:    documentsLayer,
+			profile: profile,
+		})
+	}
+	if c.envPrefix != "" {
+		prefix := c.envPrefix
+		c.layers = append(c.layers, &layer{
+			name: LayerEnv,
+			kind: overlayLayer,
+			overlay: func(config map[string]any, overrides map[string]any) error {
+				return applyEnvOverlay(config, overrides, prefix)
+			},
+		})
+	}
+	if c.setFlags != nil {
+		c.layers = append(c.layers, &layer{
+			name:    LayerFlags,
+			kind:    overlayLayer,
+			overlay: c.setFlags.apply,
+		})
+	}
+	c.layers = append(c.layers, &layer{name: LayerRuntime, values: make(map[string]any)})
+}
+
+func (c *Config) findLayer(name string) *layer {
+	for _, l := range c.layers {
+		if l.name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// Layers returns the names of the layers of the config, from lowest to
+// highest precedence.
+func (c *Config) Layers() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, len(c.layers))
+	for i, l := range c.layers {
+		names[i] = l.name
+	}
+	return names
+}
+
+// Layer returns a copy of the config values contributed by the named
+// layer. For the env and flags layers these are the values they set when
+// the config was last computed.
+func (c *Config) Layer(name string) (map[string]any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.findLayer(name)
+	if l == nil {
+		return nil, false
+	}
+	if l.kind == documentsLayer {
+		finalDict := make(map[string]any)
+		if err := mergeDocuments(l.docs, finalDict); err != nil {
+			return nil, false
+		}
+		config, _ := finalDict["Config"].(map[string]any)
+		return deepClone(config), true
+	}
+	return deepClone(l.values), true
+}
+
+// SetLayer replaces the content of the named layer with config values and
+// applies the recomputed config. A replaced profile layer is read again
+// on the next Reload, other layers keep the replacement. A rejected
+// replacement is discarded.
+func (c *Config) SetLayer(name string, values map[string]any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.findLayer(name)
+	if l == nil {
+		return &Exception{message: fmt.Sprintf("unknown layer %s", name)}
+	}
+	saved := c.saveLayers()
+	l.kind = valuesLayer
+	l.docs = nil
+	l.overlay = nil
+	l.values = deepClone(values)
+	return c.updateOrRestore(saved)
+}
+
+// Override deep-merges values into the runtime layer, which takes
+// precedence over all other layers and survives Reload, and applies the
+// recomputed config. Tombstones, e.g. {"$delete": true}, delete values of
+// the lower layers. A rejected override is discarded.
+func (c *Config) Override(values map[string]any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.findLayer(LayerRuntime)
+	merged, err := mergeOverride(l.values, values)
+	if err != nil {
+		return err
+	}
+	saved := c.saveLayers()
+	l.values = merged
+	return c.updateOrRestore(saved)
+}
+
+// mergeOverride deep-merges overlay onto the runtime values base like
+// mergeMaps, but keeps tombstones, so that they delete the values of the
+// lower layers when the runtime layer is merged onto them.
+func mergeOverride(base, overlay map[string]any) (map[string]any, error) {
+	merged := deepClone(base)
+	for key, overlayValue := range overlay {
+		baseMap, baseIsMap := merged[key].(map[string]any)
+		overlayMap, overlayIsMap := overlayValue.(map[string]any)
+		switch {
+		case isTombstone(overlayValue):
+			merged[key] = cloneValue(overlayValue)
+		case baseIsMap && overlayIsMap && !isTombstone(baseMap):
+			value, err := mergeOverride(baseMap, overlayMap)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			merged[key] = value
+		case overlayIsMap:
+			merged[key] = cloneValue(overlayMap)
+		default:
+			value, err := mergeValues(merged[key], overlayValue)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			merged[key] = value
+		}
+	}
+	return merged, nil
+}
+
+// saveLayers returns a copy of the state of the layers, see
+// updateOrRestore.
+func (c *Config) saveLayers() []layer {
+	saved := make([]layer, len(c.layers))
+	for i, l := range c.layers {
+		saved[i] = *l
+	}
+	return saved
+}
+
+// updateOrRestore applies the config computed from the current layers.
+// If that fails, it restores the layers to saved, so that a rejected
+// change does not stay in the stack and fail every later update.
+func (c *Config) updateOrRestore(saved []layer) error {
+	err := c.update()
+	if err != nil {
+		for i, l := range c.layers {
+			*l = saved[i]
+		}
+	}
+	return err
+}
+
+// compute folds the layers into the effective documents, keyed by kind
+// and name, and resolves metadata.extends.
+func (c *Config) compute() (map[string]any, provenance, error) {
+	finalDict := make(map[string]any)
+	prov := make(provenance)
+	// Documents inherit from their base once all profiles are merged, so
+	// that the layers above see the values of the derived documents.
+	lastProfile := len(c.layers) - 1
+	for i, l := range c.layers {
+		if l.kind == documentsLayer {
+			lastProfile = i
+		}
+	}
+	for i, l := range c.layers {
+		if err := l.mergeInto(finalDict, prov); err != nil {
+			return nil, nil, fmt.Errorf("layer %s: %w", l.name, err)
+		}
+		if i == lastProfile {
+			if err := resolveExtends(finalDict, prov); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if _, ok := finalDict["Config"].(map[string]any); !ok {
+		finalDict["Config"] = make(map[string]any)
+	}
+	return finalDict, prov, nil
+}
+
+// update applies the documents computed from the current layers. The
+// Config and all registered kinds are validated and decoded before any
+// is deployed, and the result is kept only once all are deployed. The
+// caller holds c.mu.
+func (c *Config) update() error {
+	finalDict, prov, err := c.compute()
+	if err != nil {
+		return err
+	}
+	config := finalDict["Config"].(map[string]any)
+	configDiffResult, err := c.check(config, prov)
+	if err != nil {
+		return err
+	}
+	kinds, err := c.checkKinds(finalDict)
+	if err != nil {
+		return err
+	}
+	if errs := c.deploy(config, configDiffResult); errs != nil {
+		return errors.Join(errs...)
+	}
+	if errs := c.deployKinds(finalDict, kinds); errs != nil {
+		return errors.Join(errs...)
+	}
+	c.config = config
+	c.provenance = prov
+	c.documents = finalDict
+	c.decoded = kinds.decoded
+	return nil
+}
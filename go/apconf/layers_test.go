@@ -0,0 +1,154 @@
+package apconf
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// nolint: funlen
+func TestLayers(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  num_workers: 30
+  queue: default
+`,
+		"prod/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  queue: prod
+`,
+	})
+	t.Setenv("APCONF__CRAWLER_CONFIG__SPEC__NUM_WORKERS", "40")
+
+	cfg, err := LoadConfig(root, []string{"base", "prod"}, nil, nil, nil, nil,
+		WithDefaults(msa{"crawler_config": msa{"spec": msa{"retries": 3}}}),
+		WithEnvOverlay("APCONF"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	spec := func() msa {
+		return cfg.config["crawler_config"].(msa)["spec"].(msa)
+	}
+
+	expectedLayers := []string{LayerDefaults, ProfileLayer("base"), ProfileLayer("prod"), LayerEnv, LayerRuntime}
+	if !reflect.DeepEqual(cfg.Layers(), expectedLayers) {
+		t.Errorf("Expected layers %v, got %v", expectedLayers, cfg.Layers())
+	}
+	expected := msa{"num_workers": 40, "queue": "prod", "retries": 3}
+	if !reflect.DeepEqual(spec(), expected) {
+		t.Errorf("Expected spec %v, got %v", expected, spec())
+	}
+
+	t.Run("inspect", func(t *testing.T) {
+		prod, ok := cfg.Layer(ProfileLayer("prod"))
+		if !ok || prod["crawler_config"].(msa)["spec"].(msa)["queue"] != "prod" {
+			t.Errorf("Unexpected prod layer %v", prod)
+		}
+		env, _ := cfg.Layer(LayerEnv)
+		if !reflect.DeepEqual(env, msa{"crawler_config": msa{"spec": msa{"num_workers": 40}}}) {
+			t.Errorf("Unexpected env layer %v", env)
+		}
+		if _, ok := cfg.Layer("missing"); ok {
+			t.Errorf("Expected no layer named missing")
+		}
+	})
+
+	t.Run("runtime_override_survives_reload", func(t *testing.T) {
+		if err := cfg.Override(msa{"crawler_config": msa{"spec": msa{"num_workers": 99}}}); err != nil {
+			t.Fatalf("Failed to override: %v", err)
+		}
+		writeFile(t, filepath.Join(root, "prod", "crawl.yaml"), `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  queue: prod2
+`)
+		if err := cfg.Reload(); err != nil {
+			t.Fatalf("Failed to reload: %v", err)
+		}
+		expected := msa{"num_workers": 99, "queue": "prod2", "retries": 3}
+		if !reflect.DeepEqual(spec(), expected) {
+			t.Errorf("Expected spec %v, got %v", expected, spec())
+		}
+	})
+
+	t.Run("runtime_delete", func(t *testing.T) {
+		if err := cfg.Override(msa{"crawler_config": msa{"spec": msa{"queue": msa{"$delete": true}}}}); err != nil {
+			t.Fatalf("Failed to override: %v", err)
+		}
+		if _, ok := spec()["queue"]; ok {
+			t.Errorf("Expected queue to be deleted, got %v", spec())
+		}
+		if err := cfg.Override(msa{"crawler_config": msa{"spec": msa{"queue": "runtime"}}}); err != nil {
+			t.Fatalf("Failed to override: %v", err)
+		}
+		if queue := spec()["queue"]; queue != "runtime" {
+			t.Errorf("Expected queue to be set again, got %v", queue)
+		}
+	})
+
+	t.Run("replace_layer", func(t *testing.T) {
+		if err := cfg.SetLayer(LayerRuntime, nil); err != nil {
+			t.Fatalf("Failed to replace runtime layer: %v", err)
+		}
+		if err := cfg.SetLayer(LayerDefaults, msa{"crawler_config": msa{"spec": msa{"retries": 5}}}); err != nil {
+			t.Fatalf("Failed to replace defaults layer: %v", err)
+		}
+		expected := msa{"num_workers": 40, "queue": "prod2", "retries": 5}
+		if !reflect.DeepEqual(spec(), expected) {
+			t.Errorf("Expected spec %v, got %v", expected, spec())
+		}
+		if err := cfg.SetLayer("missing", nil); err == nil {
+			t.Errorf("Expected an error for an unknown layer")
+		}
+	})
+}
+
+func TestRejectedLayerChanges(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yaml": "kind: Config\nmetadata:\n  name: crawler_config\nspec:\n  n: 1\n",
+	})
+	positive := func(configNew, _ map[string]any, _ ConfigDiffResult) bool {
+		n, _ := configNew["crawler_config"].(msa)["spec"].(msa)["n"].(int)
+		return n > 0
+	}
+	cfg, err := LoadConfig(root, []string{"base"}, nil, nil, nil,
+		[]func(map[string]any, map[string]any, ConfigDiffResult) bool{positive})
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	n := func() any {
+		return cfg.config["crawler_config"].(msa)["spec"].(msa)["n"]
+	}
+
+	if err := cfg.Override(msa{"crawler_config": msa{"spec": msa{"n": -1}}}); err == nil {
+		t.Fatalf("Expected the override to fail validation")
+	}
+	if runtime, _ := cfg.Layer(LayerRuntime); len(runtime) != 0 {
+		t.Errorf("Expected the rejected override to be discarded, got %v", runtime)
+	}
+	if err := cfg.SetLayer(ProfileLayer("base"), msa{"crawler_config": msa{"spec": msa{"n": 0}}}); err == nil {
+		t.Fatalf("Expected the replaced layer to fail validation")
+	}
+	if err := cfg.Reload(); err != nil {
+		t.Fatalf("Expected reload to succeed after rejected changes, got %v", err)
+	}
+
+	writeFile(t, filepath.Join(root, "base", "crawl.yaml"),
+		"kind: Config\nmetadata:\n  name: crawler_config\nspec:\n  n: -2\n")
+	if err := cfg.Reload(); err == nil {
+		t.Fatalf("Expected the reload to fail validation")
+	}
+	base, _ := cfg.Layer(ProfileLayer("base"))
+	if got := base["crawler_config"].(msa)["spec"].(msa)["n"]; got != 1 || n() != 1 {
+		t.Errorf("Expected the applied documents to stay in the layer, got %v and config %v", got, n())
+	}
+	if err := cfg.Override(msa{"crawler_config": msa{"spec": msa{"n": 2}}}); err != nil || n() != 2 {
+		t.Errorf("Expected a valid override to apply, got %v and config %v", err, n())
+	}
+}
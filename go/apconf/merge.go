@@ -0,0 +1,247 @@
+package apconf
+
+import (
+	"fmt"
+	"reflect"
+)
+
+const (
+	mergeStrategyMerge   = "merge"
+	mergeStrategyReplace = "replace"
+
+	// List directives, see mergeLists.
+	mergeKeyDirective = "$mergeKey"
+	patchDirective    = "$patch"
+	valueDirective    = "$value"
+
+	// Tombstone marking a key for deletion, e.g. "level: {$delete: true}".
+	deleteDirective = "$delete"
+
+	patchMerge   = "merge"
+	patchReplace = "replace"
+	patchDelete  = "delete"
+)
+
+// documentMergeStrategy returns the metadata.mergeStrategy of a document,
+// defaulting to a deep merge.
+func documentMergeStrategy(doc map[string]any) (string, error) {
+	metadata, _ := doc["metadata"].(map[string]any)
+	strategy, exists := metadata["mergeStrategy"]
+	if !exists {
+		return mergeStrategyMerge, nil
+	}
+	switch strategy {
+	case mergeStrategyMerge, mergeStrategyReplace:
+		return strategy.(string), nil
+	default:
+		return "", fmt.Errorf(
+			"unknown metadata.mergeStrategy %v, expected %q or %q",
+			strategy, mergeStrategyMerge, mergeStrategyReplace)
+	}
+}
+
+// mergeValues merges overlay onto base. Maps are merged recursively,
+// lists according to their directives and any other overlay value
+// replaces the base value.
+func mergeValues(base, overlay any) (any, error) {
+	switch o := overlay.(type) {
+	case map[string]any:
+		baseMap, _ := base.(map[string]any)
+		return mergeMaps(baseMap, o)
+	case []any:
+		baseList, _ := base.([]any)
+		return mergeLists(baseList, o)
+	default:
+		return o, nil
+	}
+}
+
+// mergeMaps deep-merges overlay onto base and returns the result.
+// Neither input is modified. A nil base yields overlay with all merge
+// directives resolved.
+func mergeMaps(base, overlay map[string]any) (map[string]any, error) {
+	merged := deepClone(base)
+	for key, overlayValue := range overlay {
+		if isTombstone(overlayValue) {
+			delete(merged, key)
+			continue
+		}
+		value, err := mergeValues(merged[key], overlayValue)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		merged[key] = value
+	}
+	return merged, nil
+}
+
+// isTombstone tells whether an overlay value deletes its key.
+func isTombstone(value any) bool {
+	m, ok := value.(map[string]any)
+	return ok && m[deleteDirective] == true
+}
+
+// isDeletedDocument tells whether a document removes its counterpart
+// from lower profiles via metadata.deleted.
+func isDeletedDocument(doc map[string]any) bool {
+	metadata, _ := doc["metadata"].(map[string]any)
+	return metadata["deleted"] == true
+}
+
+// mergeLists merges an overlay list onto a base list. By default the
+// overlay replaces the base. A leading directive item changes that:
+//
+//	handlers:
+//	  - $mergeKey: name   # merge map items whose "name" fields match
+//	  # or: $patch: merge    union of scalar items
+//	  # or: $patch: replace  replace the base list (the default)
+//	  - name: file
+//	    level: debug
+//
+// With $mergeKey, an item carrying "$patch: delete" removes the matching
+// base item and "$patch: replace" replaces it instead of merging. With
+// "$patch: merge", an item {$patch: delete, $value: x} removes x.
+func mergeLists(base, overlay []any) ([]any, error) {
+	directive, items := splitListDirective(overlay)
+	mergeKey, keyed := directive[mergeKeyDirective]
+	switch {
+	case keyed:
+		key, ok := mergeKey.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a string, got %v", mergeKeyDirective, mergeKey)
+		}
+		return mergeKeyedLists(base, items, key)
+	case directive[patchDirective] == patchMerge:
+		return mergeScalarLists(base, items)
+	case directive[patchDirective] == nil || directive[patchDirective] == patchReplace:
+		return mergeKeyedLists(nil, items, "")
+	default:
+		return nil, fmt.Errorf("unknown list %s %v", patchDirective, directive[patchDirective])
+	}
+}
+
+// splitListDirective separates the leading directive item, if any,
+// from the items of a list.
+func splitListDirective(list []any) (map[string]any, []any) {
+	if len(list) == 0 {
+		return nil, list
+	}
+	first, ok := list[0].(map[string]any)
+	if !ok || len(first) == 0 {
+		return nil, list
+	}
+	for key := range first {
+		if key != mergeKeyDirective && key != patchDirective {
+			return nil, list
+		}
+	}
+	return first, list[1:]
+}
+
+func mergeKeyedLists(base, items []any, key string) ([]any, error) {
+	merged, _ := cloneValue(base).([]any)
+	for i, item := range items {
+		itemMap, ok := item.(map[string]any)
+		if !ok {
+			value, err := mergeValues(nil, item)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %w", i, err)
+			}
+			merged = append(merged, value)
+			continue
+		}
+		patch, hasPatch := itemMap[patchDirective]
+		itemMap = withoutKeys(itemMap, patchDirective)
+
+		index := -1
+		if itemKey, ok := itemMap[key]; ok && key != "" {
+			index = findListItem(merged, func(candidate any) bool {
+				candidateMap, ok := candidate.(map[string]any)
+				return ok && reflect.DeepEqual(candidateMap[key], itemKey)
+			})
+		}
+
+		switch {
+		case patch == patchDelete:
+			if index >= 0 {
+				merged = append(merged[:index], merged[index+1:]...)
+			}
+			continue
+		case !hasPatch || patch == patchMerge || patch == patchReplace:
+		default:
+			return nil, fmt.Errorf("item %d: unknown %s %v", i, patchDirective, patch)
+		}
+
+		var baseItem map[string]any
+		if index >= 0 && patch != patchReplace {
+			baseItem, _ = merged[index].(map[string]any)
+		}
+		value, err := mergeMaps(baseItem, itemMap)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+		if index >= 0 {
+			merged[index] = value
+		} else {
+			merged = append(merged, value)
+		}
+	}
+	if merged == nil {
+		merged = []any{}
+	}
+	return merged, nil
+}
+
+func mergeScalarLists(base, items []any) ([]any, error) {
+	merged, _ := cloneValue(base).([]any)
+	for i, item := range items {
+		if itemMap, ok := item.(map[string]any); ok {
+			if patch, ok := itemMap[patchDirective]; ok {
+				if patch != patchDelete {
+					return nil, fmt.Errorf("item %d: unknown %s %v", i, patchDirective, patch)
+				}
+				value := itemMap[valueDirective]
+				if index := findListItem(merged, func(candidate any) bool {
+					return reflect.DeepEqual(candidate, value)
+				}); index >= 0 {
+					merged = append(merged[:index], merged[index+1:]...)
+				}
+				continue
+			}
+		}
+		value, err := mergeValues(nil, item)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+		if findListItem(merged, func(candidate any) bool {
+			return reflect.DeepEqual(candidate, value)
+		}) < 0 {
+			merged = append(merged, value)
+		}
+	}
+	if merged == nil {
+		merged = []any{}
+	}
+	return merged, nil
+}
+
+func findListItem(list []any, match func(any) bool) int {
+	for i, item := range list {
+		if match(item) {
+			return i
+		}
+	}
+	return -1
+}
+
+// withoutKeys returns a shallow copy of m without the given keys.
+func withoutKeys(m map[string]any, keys ...string) map[string]any {
+	result := make(map[string]any, len(m))
+	for key, value := range m {
+		result[key] = value
+	}
+	for _, key := range keys {
+		delete(result, key)
+	}
+	return result
+}
@@ -0,0 +1,245 @@
+package apconf
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// nolint: funlen
+func TestProfileOverlay(t *testing.T) {
+	base := `kind: Config
+metadata:
+  name: logging_config
+spec:
+  level: info
+  cores:
+    console:
+      level: error
+      encoding: console
+    rotating_file:
+      level: warn
+      encoding: json
+`
+	t.Run("deep_merge", func(t *testing.T) {
+		root := writeProfiles(t, map[string]string{
+			"base/logging.yaml": base,
+			"prod/logging.yaml": `kind: Config
+metadata:
+  name: logging_config
+spec:
+  cores:
+    console:
+      level: debug
+`,
+		})
+		cfg, err := LoadConfig(root, []string{"base", "prod"}, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		spec := cfg.config["logging_config"].(msa)["spec"].(msa)
+		expected := msa{
+			"level": "info",
+			"cores": msa{
+				"console":       msa{"level": "debug", "encoding": "console"},
+				"rotating_file": msa{"level": "warn", "encoding": "json"},
+			},
+		}
+		if !reflect.DeepEqual(spec, expected) {
+			t.Errorf("Expected spec %v, got %v", expected, spec)
+		}
+	})
+
+	t.Run("replace_strategy", func(t *testing.T) {
+		root := writeProfiles(t, map[string]string{
+			"base/logging.yaml": base,
+			"prod/logging.yaml": `kind: Config
+metadata:
+  name: logging_config
+  mergeStrategy: replace
+spec:
+  level: debug
+`,
+		})
+		cfg, err := LoadConfig(root, []string{"base", "prod"}, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		spec := cfg.config["logging_config"].(msa)["spec"].(msa)
+		if !reflect.DeepEqual(spec, msa{"level": "debug"}) {
+			t.Errorf("Expected replaced spec, got %v", spec)
+		}
+	})
+
+	t.Run("unknown_strategy", func(t *testing.T) {
+		root := writeProfiles(t, map[string]string{
+			"base/logging.yaml": `kind: Config
+metadata:
+  name: logging_config
+  mergeStrategy: append
+spec: {}
+`,
+		})
+		if _, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil); err == nil {
+			t.Errorf("Expected an error for unknown merge strategy")
+		}
+	})
+}
+
+// nolint: funlen
+func TestMergeLists(t *testing.T) {
+	base := msa{
+		"handlers": []any{
+			msa{"name": "console", "level": "error"},
+			msa{"name": "file", "level": "warn", "filename": "app.log"},
+		},
+		"root": msa{"handlers": []any{"file", "console"}},
+	}
+
+	t.Run("default_replace", func(t *testing.T) {
+		merged, err := mergeMaps(base, msa{"handlers": []any{msa{"name": "syslog"}}})
+		if err != nil {
+			t.Fatalf("Failed to merge: %v", err)
+		}
+		expected := []any{msa{"name": "syslog"}}
+		if !reflect.DeepEqual(merged["handlers"], expected) {
+			t.Errorf("Expected handlers %v, got %v", expected, merged["handlers"])
+		}
+	})
+
+	t.Run("merge_key", func(t *testing.T) {
+		merged, err := mergeMaps(base, msa{"handlers": []any{
+			msa{"$mergeKey": "name"},
+			msa{"name": "file", "level": "debug"},
+			msa{"name": "console", "$patch": "delete"},
+			msa{"name": "syslog", "level": "info"},
+		}})
+		if err != nil {
+			t.Fatalf("Failed to merge: %v", err)
+		}
+		expected := []any{
+			msa{"name": "file", "level": "debug", "filename": "app.log"},
+			msa{"name": "syslog", "level": "info"},
+		}
+		if !reflect.DeepEqual(merged["handlers"], expected) {
+			t.Errorf("Expected handlers %v, got %v", expected, merged["handlers"])
+		}
+	})
+
+	t.Run("item_replace", func(t *testing.T) {
+		merged, err := mergeMaps(base, msa{"handlers": []any{
+			msa{"$mergeKey": "name"},
+			msa{"name": "file", "level": "debug", "$patch": "replace"},
+		}})
+		if err != nil {
+			t.Fatalf("Failed to merge: %v", err)
+		}
+		expected := []any{
+			msa{"name": "console", "level": "error"},
+			msa{"name": "file", "level": "debug"},
+		}
+		if !reflect.DeepEqual(merged["handlers"], expected) {
+			t.Errorf("Expected handlers %v, got %v", expected, merged["handlers"])
+		}
+	})
+
+	t.Run("scalar_merge", func(t *testing.T) {
+		merged, err := mergeMaps(base, msa{"root": msa{"handlers": []any{
+			msa{"$patch": "merge"},
+			msa{"$patch": "delete", "$value": "console"},
+			"syslog",
+			"file",
+		}}})
+		if err != nil {
+			t.Fatalf("Failed to merge: %v", err)
+		}
+		expected := []any{"file", "syslog"}
+		if !reflect.DeepEqual(merged["root"].(msa)["handlers"], expected) {
+			t.Errorf("Expected handlers %v, got %v", expected, merged["root"].(msa)["handlers"])
+		}
+	})
+
+	t.Run("directives_removed_without_base", func(t *testing.T) {
+		merged, err := mergeMaps(nil, msa{"handlers": []any{
+			msa{"$mergeKey": "name"},
+			msa{"name": "file"},
+		}})
+		if err != nil {
+			t.Fatalf("Failed to merge: %v", err)
+		}
+		expected := []any{msa{"name": "file"}}
+		if !reflect.DeepEqual(merged["handlers"], expected) {
+			t.Errorf("Expected handlers %v, got %v", expected, merged["handlers"])
+		}
+	})
+
+	t.Run("unknown_patch", func(t *testing.T) {
+		_, err := mergeMaps(base, msa{"handlers": []any{
+			msa{"$mergeKey": "name"},
+			msa{"name": "file", "$patch": "upsert"},
+		}})
+		if err == nil {
+			t.Errorf("Expected an error for unknown $patch")
+		}
+	})
+}
+
+func TestTombstones(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  num_workers: 30
+  proxy: localhost:3128
+---
+kind: Config
+metadata:
+  name: legacy_config
+spec:
+  enabled: true
+`,
+		"prod/.keep": "",
+	})
+	var lastDiff ConfigDiffResult
+	deployers := []func(msa, msa, ConfigDiffResult) error{
+		func(_ msa, _ msa, diff ConfigDiffResult) error {
+			lastDiff = diff
+			return nil
+		},
+	}
+	cfg, err := LoadConfig(root, []string{"base", "prod"}, nil, nil, deployers, nil)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	writeFile(t, filepath.Join(root, "prod", "crawl.yaml"), `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  proxy: {$delete: true}
+---
+kind: Config
+metadata:
+  name: legacy_config
+  deleted: true
+`)
+	if err := cfg.Reload(); err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+
+	if _, ok := cfg.config["legacy_config"]; ok {
+		t.Errorf("Expected legacy_config to be deleted")
+	}
+	spec := cfg.config["crawler_config"].(msa)["spec"].(msa)
+	if !reflect.DeepEqual(spec, msa{"num_workers": 30}) {
+		t.Errorf("Expected proxy to be deleted, got %v", spec)
+	}
+	if !lastDiff.Contains([]string{"legacy_config"}) ||
+		!lastDiff.Contains([]string{"crawler_config", "spec", "proxy"}) {
+		t.Errorf("Expected deletions in diff, got removed %v", lastDiff.Removed)
+	}
+	if _, ok := lastDiff.Removed["legacy_config"]; !ok {
+		t.Errorf("Expected legacy_config in removed, got %v", lastDiff.Removed)
+	}
+}
@@ -0,0 +1,166 @@
+package apconf
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envPathSeparator = "__"
+
+// envOverlay returns the config paths and values of the environment
+// variables named like PREFIX__CRAWLER_CONFIG__SPEC__NUM_WORKERS, in
+// lexical order of the variable names.
+func envOverlay(prefix string, environ []string) ([][]string, []string) {
+	sort.Strings(environ)
+	var paths [][]string
+	var values []string
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		rest, ok := strings.CutPrefix(name, prefix+envPathSeparator)
+		if !ok || rest == "" {
+			continue
+		}
+		path := strings.Split(strings.ToLower(rest), envPathSeparator)
+		paths = append(paths, path)
+		values = append(values, value)
+	}
+	return paths, values
+}
+
+// applyEnvOverlay sets the config values given by the environment
+// variables with the given prefix, coercing each value to the type of
+// the value it replaces. The values set are also recorded in overrides.
+func applyEnvOverlay(config map[string]any, overrides map[string]any, prefix string) error {
+	paths, values := envOverlay(prefix, os.Environ())
+	for i, path := range paths {
+		raw := values[i]
+		err := setPath(config, path, func(old any, exists bool) (any, error) {
+			value, err := coerceValue(raw, old, exists)
+			if err == nil {
+				recordOverride(overrides, path, value)
+			}
+			return value, err
+		})
+		if err != nil {
+			return fmt.Errorf("invalid environment override %s: %w",
+				prefix+envPathSeparator+strings.ToUpper(strings.Join(path, envPathSeparator)), err)
+		}
+	}
+	return nil
+}
+
+// recordOverride sets value at path in the nested map overrides, which
+// may be nil.
+func recordOverride(overrides map[string]any, path []string, value any) {
+	if overrides == nil {
+		return
+	}
+	current := overrides
+	for _, segment := range path[:len(path)-1] {
+		next, ok := current[segment].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			current[segment] = next
+		}
+		current = next
+	}
+	current[path[len(path)-1]] = cloneValue(value)
+}
+
+// setPath replaces the value at path in config with the result of value,
+// creating missing maps inside the document named by the first segment,
+// which must exist. Keys are matched case-insensitively if there is no
+// exact match, list items are addressed by index.
+func setPath(
+	config map[string]any, path []string, value func(old any, exists bool) (any, error)) error {
+
+	if _, ok := config[lookupKey(config, path[0])]; !ok {
+		return fmt.Errorf("no document named %s", path[0])
+	}
+	var current any = config
+	for i, segment := range path {
+		last := i == len(path)-1
+		switch node := current.(type) {
+		case map[string]any:
+			key := lookupKey(node, segment)
+			if last {
+				old, exists := node[key]
+				newValue, err := value(old, exists)
+				if err != nil {
+					return err
+				}
+				node[key] = newValue
+				return nil
+			}
+			if _, ok := node[key]; !ok {
+				node[key] = make(map[string]any)
+			}
+			current = node[key]
+		case []any:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return fmt.Errorf("%s: no list item %q", strings.Join(path[:i], "."), segment)
+			}
+			if last {
+				newValue, err := value(node[index], true)
+				if err != nil {
+					return err
+				}
+				node[index] = newValue
+				return nil
+			}
+			current = node[index]
+		default:
+			return fmt.Errorf("%s is not a map or a list", strings.Join(path[:i], "."))
+		}
+	}
+	return nil
+}
+
+func lookupKey(m map[string]any, key string) string {
+	if _, ok := m[key]; ok {
+		return key
+	}
+	for existing := range m {
+		if strings.EqualFold(existing, key) {
+			return existing
+		}
+	}
+	return key
+}
+
+// coerceValue converts raw to the type of old. New values, and values
+// replacing maps or lists, are parsed as YAML.
+func coerceValue(raw string, old any, exists bool) (any, error) {
+	if !exists {
+		return parseYamlValue(raw)
+	}
+	switch old.(type) {
+	case string:
+		return raw, nil
+	case int:
+		return strconv.Atoi(raw)
+	case float64:
+		return strconv.ParseFloat(raw, 64)
+	case bool:
+		return strconv.ParseBool(raw)
+	case time.Duration:
+		return time.ParseDuration(raw)
+	default:
+		return parseYamlValue(raw)
+	}
+}
+
+func parseYamlValue(raw string) (any, error) {
+	var value any
+	if err := yaml.Unmarshal([]byte(raw), &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,95 @@
+package apconf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// nolint: funlen
+func TestEnvOverlay(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  num_workers: 30
+  ratio: 0.5
+  enabled: false
+  queue: default
+  hosts: [a, b]
+---
+kind: Config
+metadata:
+  name: zap_logging_config
+spec:
+  cores:
+    rotating_file:
+      outputPath: /tmp/app.log
+`,
+	})
+
+	t.Run("typed_overrides", func(t *testing.T) {
+		t.Setenv("APCONF__CRAWLER_CONFIG__SPEC__NUM_WORKERS", "50")
+		t.Setenv("APCONF__CRAWLER_CONFIG__SPEC__RATIO", "0.75")
+		t.Setenv("APCONF__CRAWLER_CONFIG__SPEC__ENABLED", "true")
+		t.Setenv("APCONF__CRAWLER_CONFIG__SPEC__QUEUE", "42")
+		t.Setenv("APCONF__CRAWLER_CONFIG__SPEC__HOSTS__1", "c")
+		t.Setenv("APCONF__CRAWLER_CONFIG__SPEC__RETRIES", "3")
+		t.Setenv("APCONF__ZAP_LOGGING_CONFIG__SPEC__CORES__ROTATING_FILE__OUTPUTPATH", "/var/log/app.log")
+		t.Setenv("OTHER__CRAWLER_CONFIG__SPEC__NUM_WORKERS", "10")
+
+		cfg, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil, WithEnvOverlay("APCONF"))
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		spec := cfg.config["crawler_config"].(msa)["spec"].(msa)
+		expected := msa{
+			"num_workers": 50,
+			"ratio":       0.75,
+			"enabled":     true,
+			"queue":       "42",
+			"hosts":       []any{"a", "c"},
+			"retries":     3,
+		}
+		if !reflect.DeepEqual(spec, expected) {
+			t.Errorf("Expected spec %v, got %v", expected, spec)
+		}
+		// nolint: lll
+		outputPath := cfg.config["zap_logging_config"].(msa)["spec"].(msa)["cores"].(msa)["rotating_file"].(msa)["outputPath"]
+		if outputPath != "/var/log/app.log" {
+			t.Errorf("Expected outputPath to be overridden, got %v", outputPath)
+		}
+	})
+
+	t.Run("before_preprocessors", func(t *testing.T) {
+		t.Setenv("APCONF__CRAWLER_CONFIG__SPEC__NUM_WORKERS", "50")
+		var seen any
+		preprocessors := []func(msa){
+			func(config msa) { seen = config["crawler_config"].(msa)["spec"].(msa)["num_workers"] },
+		}
+		if _, err := LoadConfig(root, []string{"base"}, nil, preprocessors, nil, nil,
+			WithEnvOverlay("APCONF")); err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if seen != 50 {
+			t.Errorf("Expected preprocessors to see 50, got %v", seen)
+		}
+	})
+
+	t.Run("unknown_document", func(t *testing.T) {
+		t.Setenv("APCONF__TYPO__SPEC__X", "5")
+		if _, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil,
+			WithEnvOverlay("APCONF")); err == nil || !strings.Contains(err.Error(), "typo") {
+			t.Errorf("Expected an error for an unknown document, got %v", err)
+		}
+	})
+
+	t.Run("coercion_error", func(t *testing.T) {
+		t.Setenv("APCONF__CRAWLER_CONFIG__SPEC__NUM_WORKERS", "many")
+		if _, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil,
+			WithEnvOverlay("APCONF")); err == nil {
+			t.Errorf("Expected an error for a non-integer override")
+		}
+	})
+}
@@ -0,0 +1,283 @@
+package apconf
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// ReferencedParams returns the template params used by the config files
+// of the profiles configBasenames and those they require, as sorted dotted
+// paths, e.g. "db.host". It takes the options of LoadConfig, so the files
+// are read from the same source and parsed in the same template mode, but
+// nothing is applied. Besides the templates, it lists the params of
+// metadata.when expressions and those used by $include fragments. These are
+// found by rendering the files with the params of the manifests and values
+// files, so they are missed in files that fail to render without the
+// other params.
+func ReferencedParams(
+	configRoot string, configBasenames []string, opts ...Option) ([]string, error) {
+
+	c := &Config{configRoot: configRoot}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.source == nil {
+		c.source = NewDirSource(configRoot)
+	}
+	profiles, manifestParams, err := resolveProfiles(c.source, configBasenames)
+	if err != nil {
+		return nil, err
+	}
+	if c.templateParams, err = c.loadValuesFiles(manifestParams, profiles); err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]bool)
+	for _, profile := range profiles {
+		names, err := c.listProfile(profile)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if _, ok := decoderFor(path.Ext(name)); !ok || isProfileFile(name) {
+				continue
+			}
+			if matched, err := c.filter.match(name); err != nil {
+				return nil, fmt.Errorf("invalid include or exclude pattern: %w", err)
+			} else if !matched {
+				continue
+			}
+			if err := c.collectFileParams(profile, name, refs, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	params := make([]string, 0, len(refs))
+	for param := range refs {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	return params, nil
+}
+
+// collectFileParams adds to refs the params used by the file name of
+// profile, by its metadata.when expressions and by the fragments it
+// includes. stack holds the files including it.
+func (c *Config) collectFileParams(
+	profile, name string, refs map[string]bool, stack []string) error {
+
+	key := path.Join(profile, name)
+	if slices.Contains(stack, key) {
+		// The cycle fails loading, it adds no params
+		return nil
+	}
+	stack = append(stack, key)
+	content, err := c.source.Read(profile, name)
+	if err != nil {
+		return err
+	}
+	if err := c.collectParams(content, refs); err != nil {
+		return &TemplateError{Dir: sourceLocation(c.source, profile), File: name, Err: err}
+	}
+
+	decode, ok := decoderFor(path.Ext(name))
+	if !ok {
+		return nil
+	}
+	rendered, _, err := c.render(content)
+	if err != nil {
+		return nil
+	}
+	values, _, err := decode(rendered, c.tagFileReader(profile))
+	if err != nil {
+		return nil
+	}
+	var includes []string
+	for _, value := range values {
+		collectDocumentParams(value, refs, &includes)
+	}
+	for _, include := range includes {
+		fragmentProfile, fragmentName, err := resolveInclude(profile, include)
+		if err != nil {
+			return &IncludeError{
+				Dir: sourceLocation(c.source, profile), File: name, Include: include, Err: err,
+			}
+		}
+		if err := c.collectFileParams(fragmentProfile, fragmentName, refs, stack); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectDocumentParams adds to refs the params of the metadata.when
+// expressions nested in value, and to includes the paths of its $include
+// directives.
+func collectDocumentParams(value any, refs map[string]bool, includes *[]string) {
+	switch v := value.(type) {
+	case map[string]any:
+		if include, ok := v[includeDirective].(string); ok {
+			*includes = append(*includes, include)
+		}
+		if metadata, ok := v["metadata"].(map[string]any); ok {
+			if when, ok := metadata["when"].(string); ok {
+				if node, err := parseExpr(when); err == nil {
+					collectExprParams(node, refs)
+				}
+			}
+		}
+		for _, item := range v {
+			collectDocumentParams(item, refs, includes)
+		}
+	case []any:
+		for _, item := range v {
+			collectDocumentParams(item, refs, includes)
+		}
+	}
+}
+
+// collectExprParams adds the params used by a when expression to refs.
+func collectExprParams(node exprNode, refs map[string]bool) {
+	switch n := node.(type) {
+	case *identNode:
+		refs[strings.Join(n.path, ".")] = true
+	case *unaryNode:
+		collectExprParams(n.operand, refs)
+	case *binaryNode:
+		collectExprParams(n.left, refs)
+		collectExprParams(n.right, refs)
+	}
+}
+
+// collectParams adds the params used by the template content to refs.
+func (c *Config) collectParams(content []byte, refs map[string]bool) error {
+	if c.templateMode == JinjaTemplates {
+		nodes, err := parseJinja(string(content))
+		if err != nil {
+			return err
+		}
+		collectJinjaParams(nodes, make(map[string]bool), refs)
+		return nil
+	}
+
+	funcs := c.templateFuncMap()
+	if c.templateMode == SnakeCaseTemplates {
+		content = preprocessTemplateForGo(content, funcs)
+	}
+	tmpl, err := template.New("configTemplate").Funcs(funcs).Parse(string(content))
+	if err != nil {
+		return err
+	}
+	walkTemplate(tmpl.Tree.Root, true, func(node parse.Node, rootDot bool) bool {
+		switch n := node.(type) {
+		case *parse.FieldNode:
+			if rootDot {
+				refs[strings.Join(n.Ident, ".")] = true
+			}
+		case *parse.VariableNode:
+			if n.Ident[0] == "$" && len(n.Ident) > 1 {
+				refs[strings.Join(n.Ident[1:], ".")] = true
+			}
+		}
+		return true
+	})
+	return nil
+}
+
+// collectJinjaParams adds the params used by nodes to refs. bound holds
+// the variables set by the template.
+func collectJinjaParams(nodes []jinjaNode, bound map[string]bool, refs map[string]bool) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *jinjaOutput:
+			collectJinjaExprParams(n.expr, bound, refs)
+		case *jinjaIf:
+			for _, branch := range n.branches {
+				collectJinjaExprParams(branch.cond, bound, refs)
+				collectJinjaParams(branch.body, bound, refs)
+			}
+			collectJinjaParams(n.elseBody, bound, refs)
+		case *jinjaFor:
+			collectJinjaExprParams(n.iter, bound, refs)
+			loopBound := map[string]bool{"loop": true}
+			for name := range bound {
+				loopBound[name] = true
+			}
+			for _, name := range n.vars {
+				loopBound[name] = true
+			}
+			collectJinjaParams(n.body, loopBound, refs)
+			collectJinjaParams(n.elseBody, bound, refs)
+		case *jinjaSet:
+			collectJinjaExprParams(n.expr, bound, refs)
+			bound[n.name] = true
+		}
+	}
+}
+
+func collectJinjaExprParams(expr jinjaExpr, bound map[string]bool, refs map[string]bool) {
+	if param, ok := jinjaParamPath(expr); ok {
+		if !bound[param[0]] {
+			refs[strings.Join(param, ".")] = true
+		}
+		return
+	}
+	switch e := expr.(type) {
+	case *jinjaList:
+		for _, item := range e.items {
+			collectJinjaExprParams(item, bound, refs)
+		}
+	case *jinjaGetItem:
+		collectJinjaExprParams(e.obj, bound, refs)
+		collectJinjaExprParams(e.key, bound, refs)
+	case *jinjaCall:
+		if e.obj != nil {
+			collectJinjaExprParams(e.obj, bound, refs)
+		}
+		for _, arg := range e.args {
+			collectJinjaExprParams(arg, bound, refs)
+		}
+	case *jinjaFilter:
+		collectJinjaExprParams(e.value, bound, refs)
+		for _, arg := range e.args {
+			collectJinjaExprParams(arg, bound, refs)
+		}
+	case *jinjaTest:
+		collectJinjaExprParams(e.value, bound, refs)
+	case *jinjaUnary:
+		collectJinjaExprParams(e.operand, bound, refs)
+	case *jinjaBinary:
+		collectJinjaExprParams(e.left, bound, refs)
+		collectJinjaExprParams(e.right, bound, refs)
+	}
+}
+
+// jinjaParamPath returns the path of a variable or of its attributes,
+// e.g. ["db", "host"] for db.host or db['host'].
+func jinjaParamPath(expr jinjaExpr) ([]string, bool) {
+	switch e := expr.(type) {
+	case *jinjaName:
+		return []string{e.name}, true
+	case *jinjaGetItem:
+		key, ok := e.key.(*jinjaLiteral)
+		if !ok {
+			return nil, false
+		}
+		name, ok := key.value.(string)
+		if !ok {
+			return nil, false
+		}
+		parent, ok := jinjaParamPath(e.obj)
+		if !ok {
+			return nil, false
+		}
+		return append(parent, name), true
+	}
+	return nil, false
+}
@@ -2,85 +2,236 @@ package apconf
 
 import (
 	"bytes"
-	"os"
+	"errors"
+	"fmt"
+	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"text/template"
+	"text/template/parse"
 	"unicode"
-
-	"gopkg.in/yaml.v3"
 )
 
-func processYamlDirs(dirs []string, templateParams map[string]any) map[string]any {
-	finalDict := make(map[string]any)
+// document is a config document and where it was loaded from.
+type document struct {
+	content map[string]any
+	profile string
+	file    string
+	// index is the position of the document in the stream of its file.
+	index int
+	// positions locates the values nested in content by their dotted path.
+	positions map[string]position
+}
 
-	for _, dirPath := range dirs {
-		entries, err := os.ReadDir(dirPath)
-		if err != nil {
-			panic(err) // Handle error as needed
+// loadProfile renders and decodes the documents of a profile, in load
+// order. Files are decoded by the Decoder registered for their extension,
+// others are skipped, as are documents not matching the label selector.
+func (c *Config) loadProfile(profile string) ([]document, error) {
+	source := c.source
+	names, err := c.listProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []document
+	dirPath := sourceLocation(source, profile)
+	for _, name := range names {
+		decode, ok := decoderFor(path.Ext(name))
+		if !ok || isProfileFile(name) {
+			continue
+		}
+		if matched, err := c.filter.match(name); err != nil {
+			return nil, fmt.Errorf("invalid include or exclude pattern: %w", err)
+		} else if !matched {
+			continue
 		}
 
-		for _, entry := range entries {
-			if entry.IsDir() ||
-				strings.HasPrefix(entry.Name(), ".") ||
-				filepath.Ext(entry.Name()) != ".yaml" {
+		content, err := source.Read(profile, name)
+		if err != nil {
+			return nil, err
+		}
+		processedContent, lines, err := c.render(content)
+		if err != nil {
+			return nil, &TemplateError{Dir: dirPath, File: name, Err: err}
+		}
+		fileDocs, err := processContent(processedContent, decode, c.tagFileReader(profile))
+		if err != nil {
+			var parseErr *ParseError
+			if errors.As(err, &parseErr) {
+				parseErr.Dir = dirPath
+				parseErr.File = name
+			}
+			return nil, err
+		}
+		for _, doc := range fileDocs {
+			toSourceLines(doc.positions, lines)
+			if err := c.expandIncludes(&doc, profile, name); err != nil {
+				return nil, err
+			}
+			labels, err := documentLabels(doc.content)
+			if err != nil {
+				return nil, &ParseError{Dir: dirPath, File: name, Document: doc.index, Err: err}
+			}
+			if !c.selector.Matches(labels) {
 				continue
 			}
+			if active, err := documentActive(doc.content, c.templateParams); err != nil {
+				err.Dir = dirPath
+				err.File = name
+				return nil, err
+			} else if !active {
+				continue
+			}
+			doc.profile = profile
+			doc.file = filepath.Join(dirPath, filepath.FromSlash(name))
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
 
-			content, err := os.ReadFile(filepath.Join(dirPath, entry.Name()))
-			if err != nil {
-				panic(err) // Handle error as needed
+// listProfile lists the documents of profile, descending into its
+// subdirectories only given WithRecursive.
+func (c *Config) listProfile(profile string) ([]string, error) {
+	if c.filter.recursive {
+		return c.source.List(profile)
+	}
+	return listShallow(c.source, profile)
+}
+
+// processContent decodes content into documents and checks that each
+// of them can be merged. readFile reads the files named by !file tags.
+func processContent(
+	content []byte, decode positionDecoder, readFile fileReader) ([]document, error) {
+
+	values, positions, err := decode(content, readFile)
+	if err != nil {
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			err = &ParseError{Err: err}
+		}
+		return nil, err
+	}
+
+	var docs []document
+	for index, value := range values {
+		var valuePositions map[string]position
+		if positions != nil {
+			valuePositions = positions[index]
+		}
+		var valueDocs []document
+		switch v := value.(type) {
+		case map[string]any:
+			valueDocs = append(valueDocs, document{content: v, index: index, positions: valuePositions})
+		case []any:
+			// A document may also hold a list of documents
+			for i, item := range v {
+				if itemDoc, ok := item.(map[string]any); ok {
+					valueDocs = append(valueDocs, document{
+						content:   itemDoc,
+						index:     index,
+						positions: subPositions(valuePositions, strconv.Itoa(i)),
+					})
+				}
+			}
+		case nil:
+			// Empty document, e.g. a trailing '---'
+		default:
+			return nil, &ParseError{
+				Document: index,
+				Err:      fmt.Errorf("expected a mapping or a list, got %T", value),
 			}
-			processedContent := renderTemplate(content, templateParams)
-			processYamlContent(processedContent, finalDict)
 		}
+		if err := mergeDocuments(valueDocs, make(map[string]any)); err != nil {
+			return nil, &ParseError{Document: index, Err: err}
+		}
+		docs = append(docs, valueDocs...)
 	}
-	return finalDict
+	return docs, nil
 }
 
-func processYamlContent(content []byte, finalDict map[string]any) {
-	// First try to unmarshal into a single document map
-	var singleDoc map[string]any
-	if err := yaml.Unmarshal(content, &singleDoc); err == nil {
-		mergeYamlDocument(singleDoc, finalDict)
-		return
+// toSourceLines converts the rendered lines of positions to the source
+// lines given by lines, see Config.render.
+func toSourceLines(positions map[string]position, lines []int) {
+	for valuePath, pos := range positions {
+		pos.line = sourceLine(lines, pos.line)
+		positions[valuePath] = pos
 	}
+}
 
-	// If unmarshalling into a single map failed, try a slice of maps
-	var multiDocs []map[string]any
-	if err := yaml.Unmarshal(content, &multiDocs); err == nil {
-		for _, doc := range multiDocs {
-			mergeYamlDocument(doc, finalDict)
+// subPositions returns the positions nested under prefix, relative to it.
+func subPositions(positions map[string]position, prefix string) map[string]position {
+	result := make(map[string]position)
+	for valuePath, pos := range positions {
+		if rest, ok := strings.CutPrefix(valuePath, prefix+"."); ok {
+			result[rest] = pos
 		}
-	} else {
-		// Handle the error properly (log, panic, or return an error)
-		panic(err)
 	}
+	return result
 }
 
-func mergeYamlDocument(doc map[string]any, finalDict map[string]any) {
+// mergeDocuments merges docs into finalDict, in order.
+func mergeDocuments(docs []document, finalDict map[string]any) error {
+	for _, doc := range docs {
+		if err := mergeYamlDocument(doc.content, finalDict); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeYamlDocument stores doc under finalDict[kind][name]. A document
+// that is already present is deep-merged with doc unless doc sets
+// metadata.mergeStrategy to "replace", or removed if doc sets
+// metadata.deleted.
+func mergeYamlDocument(doc map[string]any, finalDict map[string]any) error {
 	if kind, ok := doc["kind"].(string); ok {
 		if metadata, ok := doc["metadata"].(map[string]any); ok {
 			if name, ok := metadata["name"].(string); ok {
+				strategy, err := documentMergeStrategy(doc)
+				if err != nil {
+					return fmt.Errorf("%s %s: %w", kind, name, err)
+				}
 				if finalDict[kind] == nil {
 					finalDict[kind] = make(map[string]any)
 				}
-				finalDict[kind].(map[string]any)[name] = doc
+				docs := finalDict[kind].(map[string]any)
+				if isDeletedDocument(doc) {
+					delete(docs, name)
+					return nil
+				}
+				var base map[string]any
+				if strategy == mergeStrategyMerge {
+					base, _ = docs[name].(map[string]any)
+				}
+				merged, err := mergeMaps(base, doc)
+				if err != nil {
+					return fmt.Errorf("%s %s: %w", kind, name, err)
+				}
+				docs[name] = merged
 			}
 		}
 	}
+	return nil
 }
 
-func preprocessTemplateForGo(content []byte) []byte {
-	// This regular expression matches '{{ var_name }}' and captures 'var_name'
-	re := regexp.MustCompile(`{{\s*(\w+)\s*}}`)
+// snakeCaseVarRe matches '{{ var_name }}' and captures 'var_name'.
+var snakeCaseVarRe = regexp.MustCompile(`{{\s*(\w+)\s*}}`)
+
+func preprocessTemplateForGo(content []byte, funcs template.FuncMap) []byte {
+	re := snakeCaseVarRe
 
 	// Replace '{{ var_name }}' with '{{ .VarName }}'
 	processedContent := re.ReplaceAllFunc(content, func(match []byte) []byte {
 		// Extract the variable name
 		varName := re.FindSubmatch(match)[1]
+		if _, isFunc := funcs[string(varName)]; isFunc {
+			// A call without arguments, e.g. '{{ hostname }}'
+			return match
+		}
 		// Convert to Go style '{{ .VarName }}'
 		pascalVarName := toPascalCase(string(varName))
 		if !strings.HasPrefix(pascalVarName, ".") {
@@ -108,21 +259,56 @@ func toPascalCase(s string) string {
 	return strings.Join(parts, "")
 }
 
-func renderTemplate(content []byte, templateParams map[string]any) []byte {
-	content = preprocessTemplateForGo(content)
+// renderTemplate renders content as a Go template. It also returns the
+// source line of each rendered line, see stripLineMarkers. If strict is
+// set, undefined params fail with *UndefinedParamError.
+func renderTemplate(
+	content []byte, templateParams map[string]any, funcs template.FuncMap, strict bool,
+) ([]byte, []int, error) {
 
 	// Create a new template and parse the content into it.
-	tmpl, err := template.New("configTemplate").Parse(string(content))
+	tmpl := template.New("configTemplate").Funcs(funcs)
+	if strict {
+		tmpl = tmpl.Option("missingkey=error")
+	}
+	tmpl, err := tmpl.Parse(string(content))
 	if err != nil {
-		panic(err)
+		return nil, nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		markTextLines(t.Tree.Root, content)
+	}
+	if strict {
+		allowMissingDefaults(tmpl, templateParams)
 	}
 
 	// Use a buffer to capture the output of the template execution.
 	var renderedContent bytes.Buffer
 	err = tmpl.Execute(&renderedContent, templateParams)
 	if err != nil {
-		panic(err)
+		if strict {
+			return nil, nil, undefinedParam(err)
+		}
+		return nil, nil, err
 	}
 
-	return renderedContent.Bytes()
+	rendered, lines := stripLineMarkers(renderedContent.Bytes())
+	if strict {
+		if err := checkNoValue(tmpl, rendered, lines, templateParams); err != nil {
+			return nil, nil, err
+		}
+	}
+	return rendered, lines, nil
+}
+
+// markTextLines marks the lines of the text nodes nested in node, see
+// markLines. content is the source of the template.
+func markTextLines(node parse.Node, content []byte) {
+	walkTemplate(node, true, func(node parse.Node, _ bool) bool {
+		if text, ok := node.(*parse.TextNode); ok {
+			line := 1 + bytes.Count(content[:text.Pos], []byte("\n"))
+			text.Text = markLines(text.Text, line)
+		}
+		return true
+	})
 }
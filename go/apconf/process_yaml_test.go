@@ -0,0 +1,211 @@
+package apconf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// writeProfiles creates a config root in a temporary directory. Keys of
+// profiles are paths relative to the root, values are file contents.
+func writeProfiles(t *testing.T, profiles map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range profiles {
+		writeFile(t, filepath.Join(root, name), content)
+	}
+	return root
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+}
+
+// nolint: funlen
+func TestLoadConfigErrors(t *testing.T) {
+	t.Run("dir_not_found", func(t *testing.T) {
+		root := writeProfiles(t, map[string]string{
+			"base/a.yaml": "kind: Config\nmetadata:\n  name: a\nspec: {}\n",
+		})
+		_, err := LoadConfig(root, []string{"base", "missing"}, nil, nil, nil, nil)
+		var dirErr *DirNotFoundError
+		if !errors.As(err, &dirErr) {
+			t.Fatalf("Expected DirNotFoundError, got %v", err)
+		}
+		if dirErr.Dir != filepath.Join(root, "missing") {
+			t.Errorf("Expected dir %s, got %s", filepath.Join(root, "missing"), dirErr.Dir)
+		}
+	})
+
+	t.Run("parse_error", func(t *testing.T) {
+		root := writeProfiles(t, map[string]string{
+			"base/bad.yaml": "kind: Config\nmetadata: [unclosed\n",
+		})
+		_, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("Expected ParseError, got %v", err)
+		}
+		if parseErr.File != "bad.yaml" || parseErr.Dir != filepath.Join(root, "base") {
+			t.Errorf("Unexpected location %s/%s", parseErr.Dir, parseErr.File)
+		}
+	})
+
+	t.Run("template_error", func(t *testing.T) {
+		root := writeProfiles(t, map[string]string{
+			"base/tmpl.yaml": "kind: Config\nmetadata:\n  name: a\nspec: {{ .Foo | nosuchfunc }}\n",
+		})
+		_, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil)
+		var tmplErr *TemplateError
+		if !errors.As(err, &tmplErr) {
+			t.Fatalf("Expected TemplateError, got %v", err)
+		}
+		if tmplErr.File != "tmpl.yaml" {
+			t.Errorf("Expected file tmpl.yaml, got %s", tmplErr.File)
+		}
+	})
+
+	t.Run("deployer_error", func(t *testing.T) {
+		root := writeProfiles(t, map[string]string{
+			"base/a.yaml": "kind: Config\nmetadata:\n  name: a\nspec: {}\n",
+		})
+		deployErr := errors.New("deploy failed")
+		_, err := LoadConfig(root, []string{"base"}, nil, nil,
+			[]func(msa, msa, ConfigDiffResult) error{
+				func(msa, msa, ConfigDiffResult) error { return deployErr },
+			}, nil)
+		if !errors.Is(err, deployErr) {
+			t.Fatalf("Expected deployer error, got %v", err)
+		}
+	})
+
+	t.Run("new_config_panics", func(t *testing.T) {
+		defer func() {
+			if _, ok := recover().(*DirNotFoundError); !ok {
+				t.Errorf("Expected NewConfig to panic with DirNotFoundError")
+			}
+		}()
+		NewConfig(t.TempDir(), []string{"missing"}, nil, nil, nil, nil)
+	})
+}
+
+func TestProcessContent(t *testing.T) {
+	t.Run("multi_document_stream", func(t *testing.T) {
+		content := `---
+kind: Config
+metadata:
+  name: crawler_config
+spec:
+  num_workers: 30
+---
+kind: Config
+metadata:
+  name: logging_config
+spec:
+  version: 1
+---
+`
+		docs, err := processContent([]byte(content), decodeYaml, os.ReadFile)
+		if err != nil {
+			t.Fatalf("Failed to process content: %v", err)
+		}
+		finalDict := make(msa)
+		if err := mergeDocuments(docs, finalDict); err != nil {
+			t.Fatalf("Failed to merge documents: %v", err)
+		}
+		configs := finalDict["Config"].(msa)
+		if len(configs) != 2 {
+			t.Fatalf("Expected 2 documents, got %d", len(configs))
+		}
+		if _, ok := configs["logging_config"]; !ok {
+			t.Errorf("Expected logging_config to be loaded")
+		}
+	})
+
+	t.Run("malformed_document_index", func(t *testing.T) {
+		content := "kind: Config\nmetadata:\n  name: a\n---\nkind: Config\nmetadata: [\n"
+		_, err := processContent([]byte(content), decodeYaml, os.ReadFile)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("Expected ParseError, got %v", err)
+		}
+		if parseErr.Document != 1 {
+			t.Errorf("Expected failing document 1, got %d", parseErr.Document)
+		}
+	})
+}
+
+// nolint: funlen
+func TestRecursiveProfiles(t *testing.T) {
+	doc := func(name string, numWorkers int) string {
+		return fmt.Sprintf(
+			"kind: Config\nmetadata:\n  name: %s\nspec:\n  num_workers: %d\n", name, numWorkers)
+	}
+	root := writeProfiles(t, map[string]string{
+		"base/a.yaml":                   doc("crawler_config", 1),
+		"base/crawl/b.yaml":             doc("crawler_config", 2),
+		"base/crawl/deep/c.yaml":        doc("deep_config", 3),
+		"base/crawl/local.example.yaml": doc("example_config", 4),
+		"base/_drafts/d.yaml":           doc("draft_config", 5),
+		"base/.hidden/e.yaml":           doc("hidden_config", 6),
+	})
+	load := func(opts ...Option) msa {
+		t.Helper()
+		cfg, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil, opts...)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		return cfg.config
+	}
+	names := func(config msa) []string {
+		var result []string
+		for name := range config {
+			result = append(result, name)
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	t.Run("flat_by_default", func(t *testing.T) {
+		config := load()
+		if got := names(config); !reflect.DeepEqual(got, []string{"crawler_config"}) {
+			t.Errorf("Expected only top-level documents, got %v", got)
+		}
+	})
+
+	t.Run("recursive_with_excludes", func(t *testing.T) {
+		config := load(WithRecursive(), WithExclude("*.example.yaml", "_drafts/"))
+		expected := []string{"crawler_config", "deep_config"}
+		if got := names(config); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected documents %v, got %v", expected, got)
+		}
+		// crawl/b.yaml is loaded after a.yaml
+		if numWorkers := config["crawler_config"].(msa)["spec"].(msa)["num_workers"]; numWorkers != 2 {
+			t.Errorf("Expected 'num_workers' to be 2, but got %v", numWorkers)
+		}
+	})
+
+	t.Run("include", func(t *testing.T) {
+		config := load(WithRecursive(), WithInclude("crawl/deep/*"))
+		if got := names(config); !reflect.DeepEqual(got, []string{"deep_config"}) {
+			t.Errorf("Expected only included documents, got %v", got)
+		}
+	})
+
+	t.Run("bad_pattern", func(t *testing.T) {
+		_, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil, WithExclude("[a-"))
+		if err == nil {
+			t.Errorf("Expected an error for a malformed pattern")
+		}
+	})
+}
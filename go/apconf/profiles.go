@@ -0,0 +1,103 @@
+package apconf
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestName is the name of the optional manifest of a profile, e.g.
+//
+//	requires: [base, logging-zap-go]
+//	params:
+//	  num_workers: 4
+//
+// requires lists the profiles loaded before the profile, params holds
+// default template params. The manifest is not loaded as a document.
+const ManifestName = "profile.yaml"
+
+type profileManifest struct {
+	Requires []string       `yaml:"requires"`
+	Params   map[string]any `yaml:"params"`
+}
+
+// ProfileCycleError is returned when profiles require each other.
+type ProfileCycleError struct {
+	Cycle []string
+}
+
+func (e *ProfileCycleError) Error() string {
+	return fmt.Sprintf("profile dependency cycle: %s", strings.Join(e.Cycle, " -> "))
+}
+
+// resolveProfiles expands profiles with the profiles their manifests
+// require, transitively. Each profile comes after the profiles it requires
+// and appears once. It also returns the default template params of the
+// manifests, those of later profiles taking precedence.
+func resolveProfiles(source Source, profiles []string) ([]string, map[string]any, error) {
+	var resolved []string
+	params := make(map[string]any)
+	visited := make(map[string]bool)
+	var visiting []string
+
+	var visit func(profile string) error
+	visit = func(profile string) error {
+		if i := slices.Index(visiting, profile); i >= 0 {
+			return &ProfileCycleError{Cycle: append(slices.Clone(visiting[i:]), profile)}
+		}
+		if visited[profile] {
+			return nil
+		}
+		manifest, err := readManifest(source, profile)
+		if err != nil {
+			return err
+		}
+		visiting = append(visiting, profile)
+		for _, required := range manifest.Requires {
+			if err := visit(required); err != nil {
+				return err
+			}
+		}
+		visiting = visiting[:len(visiting)-1]
+		visited[profile] = true
+		resolved = append(resolved, profile)
+		params = mergeParams(params, manifest.Params)
+		return nil
+	}
+
+	for _, profile := range profiles {
+		if err := visit(profile); err != nil {
+			return nil, nil, err
+		}
+	}
+	return resolved, params, nil
+}
+
+// readManifest reads the manifest of profile. A profile without one has
+// an empty manifest.
+func readManifest(source Source, profile string) (profileManifest, error) {
+	var manifest profileManifest
+	names, err := listShallow(source, profile)
+	if err != nil {
+		return manifest, err
+	}
+	if !slices.Contains(names, ManifestName) {
+		return manifest, nil
+	}
+	content, err := source.Read(profile, ManifestName)
+	if err != nil {
+		return manifest, err
+	}
+	if err := yaml.Unmarshal(content, &manifest); err != nil {
+		return manifest, &ParseError{Dir: sourceLocation(source, profile), File: ManifestName, Err: err}
+	}
+	return manifest, nil
+}
+
+// Profiles returns the profiles the config is loaded from, including
+// those required by manifests, from lowest to highest precedence.
+func (c *Config) Profiles() []string {
+	return slices.Clone(c.configBasenames)
+}
@@ -0,0 +1,87 @@
+package apconf
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestProfileManifest(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/base.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  num_workers: {{ .num_workers }}
+  region: {{ .region }}
+`,
+		"base/profile.yaml": `params:
+  num_workers: 2
+  region: eu
+`,
+		"logging/logging.yaml": `kind: Config
+metadata:
+  name: logging_config
+spec: {}
+`,
+		"worker/profile.yaml": `requires: [base, logging]
+params:
+  num_workers: 8
+`,
+		"worker/worker.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  role: worker
+`,
+		"api/profile.yaml": `requires: [logging, base]
+`,
+	})
+
+	cfg, err := LoadConfig(root, []string{"worker", "api"}, map[string]any{"region": "us"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if profiles := cfg.Profiles(); !reflect.DeepEqual(profiles, []string{"base", "logging", "worker", "api"}) {
+		t.Errorf("Unexpected profiles %v", profiles)
+	}
+	expected := map[string]any{"num_workers": 8, "region": "us", "role": "worker"}
+	if spec := cfg.config["crawler_config"].(map[string]any)["spec"]; !reflect.DeepEqual(spec, expected) {
+		t.Errorf("Expected spec %v, got %v", expected, spec)
+	}
+	if _, ok := cfg.config["logging_config"]; !ok {
+		t.Errorf("Expected the required logging profile to be loaded")
+	}
+}
+
+func TestProfileManifestErrors(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"a/profile.yaml":  "requires: [b]\n",
+		"b/profile.yaml":  "requires: [c]\n",
+		"c/profile.yaml":  "requires: [a]\n",
+		"d/profile.yaml":  "requires: [missing]\n",
+		"e/profile.yaml":  "requires: {\n",
+		"ok/profile.yaml": "requires: []\n",
+	})
+
+	_, err := LoadConfig(root, []string{"ok", "a"}, nil, nil, nil, nil)
+	var cycleErr *ProfileCycleError
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("Expected a ProfileCycleError, got %v", err)
+	}
+	if !reflect.DeepEqual(cycleErr.Cycle, []string{"a", "b", "c", "a"}) {
+		t.Errorf("Unexpected cycle %v", cycleErr.Cycle)
+	}
+
+	_, err = LoadConfig(root, []string{"d"}, nil, nil, nil, nil)
+	var dirErr *DirNotFoundError
+	if !errors.As(err, &dirErr) {
+		t.Errorf("Expected a DirNotFoundError, got %v", err)
+	}
+
+	_, err = LoadConfig(root, []string{"e"}, nil, nil, nil, nil)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.File != ManifestName {
+		t.Errorf("Expected a ParseError for the manifest, got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package apconf
+
+import (
+	"strconv"
+	"strings"
+)
+
+// OriginPreprocessor is the Origin.Layer of values changed by a
+// preprocessor.
+const OriginPreprocessor = "preprocessor"
+
+// Origin is one step in the history of a config value, see Config.Explain.
+type Origin struct {
+	// Layer is the layer that set the value, or OriginPreprocessor.
+	Layer string
+	// Profile, File, Line and Column locate the value in profile layers.
+	// Line and Column are zero for file formats that do not report them.
+	// Line is the line of the file on disk. Values output by a template
+	// action, e.g. toYaml | nindent, are located at the line of the
+	// action. Column is the column in the rendered line.
+	Profile string
+	File    string
+	Line    int
+	Column  int
+	Value   any
+}
+
+// provenance maps the dotted paths of the leaves of a config to their
+// history, oldest first. Lists are leaves.
+type provenance map[string][]Origin
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// record appends an origin for every leaf of value, which is located at
+// valuePath. Tombstones clear the history of the values they delete.
+func (p provenance) record(valuePath string, value any, origin func(subPath string) Origin) {
+	p.recordAt(valuePath, "", value, origin)
+}
+
+func (p provenance) recordAt(valuePath, subPath string, value any, origin func(string) Origin) {
+	m, isMap := value.(map[string]any)
+	switch {
+	case isTombstone(value):
+		p.clear(valuePath)
+	case isMap && valuePath != "" && len(m) == 0:
+		p[valuePath] = append(p[valuePath], withValue(origin(subPath), value))
+	case isMap:
+		for key, item := range m {
+			p.recordAt(joinPath(valuePath, key), joinPath(subPath, key), item, origin)
+		}
+	default:
+		p[valuePath] = append(p[valuePath], withValue(origin(subPath), value))
+	}
+}
+
+func withValue(origin Origin, value any) Origin {
+	origin.Value = cloneValue(value)
+	return origin
+}
+
+// clear forgets the history of the value at valuePath and its leaves.
+func (p provenance) clear(valuePath string) {
+	for leaf := range p {
+		if leaf == valuePath || strings.HasPrefix(leaf, valuePath+".") {
+			delete(p, leaf)
+		}
+	}
+}
+
+// recordDocument records the leaves of a profile document.
+func (p provenance) recordDocument(layerName string, doc document) {
+	kind, _ := doc.content["kind"].(string)
+	metadata, _ := doc.content["metadata"].(map[string]any)
+	name, _ := metadata["name"].(string)
+	if kind != "Config" || name == "" {
+		return
+	}
+	if strategy, _ := documentMergeStrategy(doc.content); isDeletedDocument(doc.content) ||
+		strategy == mergeStrategyReplace {
+		p.clear(name)
+	}
+	if isDeletedDocument(doc.content) {
+		return
+	}
+	p.record(name, doc.content, func(subPath string) Origin {
+		pos := doc.positions[subPath]
+		file := doc.file
+		if pos.file != "" {
+			file = pos.file
+		}
+		return Origin{
+			Layer:   layerName,
+			Profile: doc.profile,
+			File:    file,
+			Line:    pos.line,
+			Column:  pos.column,
+		}
+	})
+}
+
+// prune forgets the leaves that are not in config.
+func (p provenance) prune(config map[string]any) {
+	for leaf := range p {
+		if !pathExists(config, strings.Split(leaf, ".")) {
+			delete(p, leaf)
+		}
+	}
+}
+
+// pathExists tells whether a value exists at path, indexing lists by
+// position.
+func pathExists(value any, path []string) bool {
+	for _, segment := range path {
+		switch v := value.(type) {
+		case map[string]any:
+			next, ok := v[segment]
+			if !ok {
+				return false
+			}
+			value = next
+		case []any:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(v) {
+				return false
+			}
+			value = v[index]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Explain returns the history of the config value at the dotted path,
+// e.g. "logging_config.spec.handlers.file_handler.filename", oldest
+// first: every layer that set it, where, and whether a preprocessor
+// changed it afterward. It returns nil for unknown paths and for maps.
+func (c *Config) Explain(valuePath string) []Origin {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	origins := c.provenance[valuePath]
+	if origins == nil {
+		return nil
+	}
+	result := make([]Origin, len(origins))
+	copy(result, origins)
+	return result
+}
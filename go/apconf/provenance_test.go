@@ -0,0 +1,152 @@
+package apconf
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// nolint: funlen
+func TestExplain(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/logging.yaml": `kind: Config
+metadata:
+  name: logging_config
+spec:
+  handlers:
+    file_handler:
+      level: WARNING
+      log_path_desc: >-
+        /tmp
+        app.log
+`,
+		"prod/logging.yaml": `kind: Config
+metadata:
+  name: logging_config
+spec:
+  handlers:
+    file_handler:
+      level: ERROR
+`,
+	})
+	t.Setenv("APCONF__LOGGING_CONFIG__SPEC__HANDLERS__FILE_HANDLER__LEVEL", "CRITICAL")
+	preprocessors := []func(msa){
+		Preprocessor(
+			func(key string) bool { return key == "log_path_desc" },
+			func(_ string) string { return "filename" },
+			func(oldValue any) any { return strings.ReplaceAll(oldValue.(string), " ", "/") },
+			false,
+		),
+	}
+	cfg, err := LoadConfig(root, []string{"base", "prod"}, nil, preprocessors, nil, nil,
+		WithEnvOverlay("APCONF"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	t.Run("profiles_and_overlay", func(t *testing.T) {
+		origins := cfg.Explain("logging_config.spec.handlers.file_handler.level")
+		if len(origins) != 3 {
+			t.Fatalf("Expected 3 origins, got %v", origins)
+		}
+		expected := []Origin{
+			{
+				Layer:   ProfileLayer("base"),
+				Profile: "base",
+				File:    filepath.Join(root, "base", "logging.yaml"),
+				Line:    7,
+				Column:  7,
+				Value:   "WARNING",
+			},
+			{
+				Layer:   ProfileLayer("prod"),
+				Profile: "prod",
+				File:    filepath.Join(root, "prod", "logging.yaml"),
+				Line:    7,
+				Column:  7,
+				Value:   "ERROR",
+			},
+			{Layer: LayerEnv, Value: "CRITICAL"},
+		}
+		for i, origin := range origins {
+			if origin != expected[i] {
+				t.Errorf("Expected origin %d to be %+v, got %+v", i, expected[i], origin)
+			}
+		}
+	})
+
+	t.Run("preprocessor", func(t *testing.T) {
+		origins := cfg.Explain("logging_config.spec.handlers.file_handler.filename")
+		if len(origins) != 1 || origins[0].Layer != OriginPreprocessor || origins[0].Value != "/tmp/app.log" {
+			t.Errorf("Expected the filename to originate from a preprocessor, got %+v", origins)
+		}
+		if origins := cfg.Explain("logging_config.spec.handlers.file_handler.log_path_desc"); origins != nil {
+			t.Errorf("Expected no history for a removed key, got %+v", origins)
+		}
+	})
+
+	t.Run("unknown_path", func(t *testing.T) {
+		if origins := cfg.Explain("logging_config.spec.handlers"); origins != nil {
+			t.Errorf("Expected no history for a map, got %+v", origins)
+		}
+	})
+}
+
+func TestExplainSourcePositions(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"go/app.yaml": `kind: Config
+metadata:
+  name: app
+spec:
+{{- if .verbose }}
+  debug: true
+{{- end }}
+  encoder: {{ toYaml .encoder | nindent 4 }}
+  hosts:
+{{- range .hosts }}
+    - {{ . }}
+{{- end }}
+  level: INFO
+`,
+		"jinja/app.yaml": `kind: Config
+metadata:
+  name: app
+spec:
+{%- if verbose %}
+  debug: true
+{%- endif %}
+  hosts:
+{%- for h in hosts %}
+    - {{ h }}
+{%- endfor %}
+  level: INFO
+`,
+	})
+	params := map[string]any{
+		"verbose": false,
+		"encoder": map[string]any{"a": 1, "b": 2},
+		"hosts":   []any{"x", "y"},
+	}
+	cases := []struct {
+		profile   string
+		mode      TemplateMode
+		valuePath string
+		line      int
+	}{
+		{"go", GoTemplates, "app.spec.level", 13},
+		{"go", GoTemplates, "app.spec.encoder.b", 8},
+		{"go", GoTemplates, "app.spec.hosts", 9},
+		{"jinja", JinjaTemplates, "app.spec.level", 12},
+		{"jinja", JinjaTemplates, "app.spec.hosts", 8},
+	}
+	for _, tc := range cases {
+		cfg, err := LoadConfig(root, []string{tc.profile}, params, nil, nil, nil, WithTemplateMode(tc.mode))
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		origins := cfg.Explain(tc.valuePath)
+		if len(origins) != 1 || origins[0].Line != tc.line {
+			t.Errorf("%s %s: expected line %d of the file, got %+v", tc.profile, tc.valuePath, tc.line, origins)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package apconf
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Selector selects documents by their metadata.labels, using the
+// Kubernetes label selector syntax, e.g. "env=prod,region in (eu,us)".
+// Supported requirements are key=value, key==value, key!=value,
+// key in (a,b), key notin (a,b), key and !key.
+type Selector struct {
+	requirements []requirement
+}
+
+type requirement struct {
+	key    string
+	op     string
+	values []string
+}
+
+const (
+	opEquals    = "="
+	opNotEquals = "!="
+	opIn        = "in"
+	opNotIn     = "notin"
+	opExists    = "exists"
+	opNotExists = "!"
+)
+
+var (
+	labelKeyRe     = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+	setRequirement = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+)
+
+// ParseSelector parses a label selector. An empty selector matches
+// everything.
+func ParseSelector(selector string) (Selector, error) {
+	var result Selector
+	for _, part := range splitRequirements(selector) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			if strings.TrimSpace(selector) == "" {
+				continue
+			}
+			return Selector{}, fmt.Errorf("invalid label selector %q: empty requirement", selector)
+		}
+		req, err := parseRequirement(part)
+		if err != nil {
+			return Selector{}, fmt.Errorf("invalid label selector %q: %w", selector, err)
+		}
+		result.requirements = append(result.requirements, req)
+	}
+	return result, nil
+}
+
+// splitRequirements splits a selector on the commas outside of sets.
+func splitRequirements(selector string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range selector {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, selector[start:])
+}
+
+func parseRequirement(part string) (requirement, error) {
+	req := requirement{}
+	switch {
+	case setRequirement.MatchString(part):
+		match := setRequirement.FindStringSubmatch(part)
+		req.key, req.op = match[1], match[2]
+		for _, value := range strings.Split(match[3], ",") {
+			req.values = append(req.values, strings.TrimSpace(value))
+		}
+	case strings.HasPrefix(part, "!"):
+		req.key, req.op = strings.TrimSpace(part[1:]), opNotExists
+	case strings.Contains(part, "!="):
+		key, value, _ := strings.Cut(part, "!=")
+		req.key, req.op = strings.TrimSpace(key), opNotEquals
+		req.values = []string{strings.TrimSpace(value)}
+	case strings.Contains(part, "="):
+		key, value, _ := strings.Cut(part, "=")
+		value = strings.TrimPrefix(value, "=")
+		req.key, req.op = strings.TrimSpace(key), opEquals
+		req.values = []string{strings.TrimSpace(value)}
+	default:
+		req.key, req.op = part, opExists
+	}
+	if !labelKeyRe.MatchString(req.key) {
+		return requirement{}, fmt.Errorf("invalid label key %q", req.key)
+	}
+	return req, nil
+}
+
+// Matches tells whether labels satisfy every requirement of the selector.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, req := range s.requirements {
+		value, exists := labels[req.key]
+		var ok bool
+		switch req.op {
+		case opEquals, opIn:
+			ok = exists && slices.Contains(req.values, value)
+		case opNotEquals, opNotIn:
+			ok = !exists || !slices.Contains(req.values, value)
+		case opExists:
+			ok = exists
+		case opNotExists:
+			ok = !exists
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// documentLabels returns the metadata.labels of a document as strings.
+// A document without labels has none.
+func documentLabels(doc map[string]any) (map[string]string, error) {
+	metadata, _ := doc["metadata"].(map[string]any)
+	rawLabels, exists := metadata["labels"]
+	if !exists || rawLabels == nil {
+		return map[string]string{}, nil
+	}
+	labelMap, ok := rawLabels.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("metadata.labels must be a mapping, got %T", rawLabels)
+	}
+	labels := make(map[string]string, len(labelMap))
+	for key, value := range labelMap {
+		labels[key] = fmt.Sprint(value)
+	}
+	return labels, nil
+}
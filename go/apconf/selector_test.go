@@ -0,0 +1,97 @@
+package apconf
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSelector(t *testing.T) {
+	cases := []struct {
+		selector string
+		labels   map[string]string
+		matches  bool
+	}{
+		{"", map[string]string{"env": "prod"}, true},
+		{"env=prod", map[string]string{"env": "prod"}, true},
+		{"env==prod", map[string]string{"env": "dev"}, false},
+		{"env!=prod", map[string]string{"env": "dev"}, true},
+		{"env!=prod", map[string]string{}, true},
+		{"env=prod,region in (eu,us)", map[string]string{"env": "prod", "region": "us"}, true},
+		{"env=prod,region in (eu, us)", map[string]string{"env": "prod", "region": "ap"}, false},
+		{"region notin (eu,us)", map[string]string{"region": "ap"}, true},
+		{"tier", map[string]string{"tier": "gold"}, true},
+		{"!tier", map[string]string{"tier": "gold"}, false},
+		{"app.example.com/tier=gold", map[string]string{"app.example.com/tier": "gold"}, true},
+	}
+	for _, tc := range cases {
+		selector, err := ParseSelector(tc.selector)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", tc.selector, err)
+			continue
+		}
+		if got := selector.Matches(tc.labels); got != tc.matches {
+			t.Errorf("Expected %q matching %v to be %v", tc.selector, tc.labels, tc.matches)
+		}
+	}
+
+	for _, invalid := range []string{"env=prod,", "=prod", "region in (eu", "env=prod,,tier"} {
+		if _, err := ParseSelector(invalid); err == nil {
+			t.Errorf("Expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  num_workers: 30
+---
+kind: Config
+metadata:
+  name: eu_config
+  labels:
+    region: eu
+spec: {}
+---
+kind: Config
+metadata:
+  name: us_prod_config
+  labels:
+    region: us
+    env: prod
+spec: {}
+`,
+	})
+	load := func(selector string) []string {
+		t.Helper()
+		cfg, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil, WithLabelSelector(selector))
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		var names []string
+		for name := range cfg.config {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	if names := load("env=prod,region in (eu,us)"); !reflect.DeepEqual(names, []string{"us_prod_config"}) {
+		t.Errorf("Expected only us_prod_config, got %v", names)
+	}
+	if names := load("region=eu"); !reflect.DeepEqual(names, []string{"eu_config"}) {
+		t.Errorf("Expected only eu_config, got %v", names)
+	}
+	// Unlabeled documents have no labels, so they match negative selectors
+	expected := []string{"crawler_config", "eu_config"}
+	if names := load("env!=prod"); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+	if _, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil, WithLabelSelector("in (")); err == nil {
+		t.Errorf("Expected an error for an invalid selector")
+	}
+}
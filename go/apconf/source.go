@@ -0,0 +1,315 @@
+package apconf
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Source is where profiles and their documents come from.
+type Source interface {
+	// List returns the names of the documents of a profile in load order.
+	// Documents in subdirectories are named by their slash-separated path
+	// relative to the profile.
+	List(profile string) ([]string, error)
+	// Read returns the raw content of a document of a profile.
+	Read(profile, name string) ([]byte, error)
+}
+
+// Watcher is implemented by sources that can report changes.
+type Watcher interface {
+	// Watch calls onChange whenever a document of one of the profiles
+	// changes. It returns immediately; watching stops when ctx is done.
+	Watch(ctx context.Context, profiles []string, onChange func()) error
+}
+
+// ShallowLister is implemented by sources that can list the documents of
+// a profile without descending into its subdirectories. Unless
+// WithRecursive is given, profiles are listed through it, so large or
+// unreadable subdirectories do not slow down or fail loading.
+type ShallowLister interface {
+	ListShallow(profile string) ([]string, error)
+}
+
+// listShallow lists the documents at the top of a profile, through
+// ListShallow if source implements ShallowLister.
+func listShallow(source Source, profile string) ([]string, error) {
+	if lister, ok := source.(ShallowLister); ok {
+		return lister.ListShallow(profile)
+	}
+	names, err := source.List(profile)
+	if err != nil {
+		return nil, err
+	}
+	var top []string
+	for _, name := range names {
+		if !strings.Contains(name, "/") {
+			top = append(top, name)
+		}
+	}
+	return top, nil
+}
+
+// Locator is implemented by sources that can describe where a profile
+// lives, e.g. its directory. The description is used in errors.
+type Locator interface {
+	Location(profile string) string
+}
+
+func sourceLocation(source Source, profile string) string {
+	if locator, ok := source.(Locator); ok {
+		return locator.Location(profile)
+	}
+	return profile
+}
+
+// DirSource reads profiles from the subdirectories of Root.
+type DirSource struct {
+	Root string
+}
+
+func NewDirSource(root string) *DirSource {
+	return &DirSource{Root: root}
+}
+
+func (s *DirSource) Location(profile string) string {
+	return filepath.Join(s.Root, profile)
+}
+
+func (s *DirSource) List(profile string) ([]string, error) {
+	dirPath := s.Location(profile)
+	return listDocuments(os.DirFS(dirPath), ".", dirPath, true)
+}
+
+func (s *DirSource) ListShallow(profile string) ([]string, error) {
+	dirPath := s.Location(profile)
+	return listDocuments(os.DirFS(dirPath), ".", dirPath, false)
+}
+
+func (s *DirSource) Read(profile, name string) ([]byte, error) {
+	dirPath := s.Location(profile)
+	content, err := os.ReadFile(filepath.Join(dirPath, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, &ReadError{Dir: dirPath, File: name, Err: err}
+	}
+	return content, nil
+}
+
+// listDocuments walks the directory dir of fsys in lexical order,
+// skipping hidden files and directories, and all subdirectories unless
+// recursive is set. Errors are reported against location, the
+// description of dir.
+func listDocuments(fsys fs.FS, dir string, location string, recursive bool) ([]string, error) {
+	var names []string
+	err := fs.WalkDir(fsys, dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == dir {
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".") || (entry.IsDir() && !recursive) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() {
+			if dir != "." {
+				name = strings.TrimPrefix(name, dir+"/")
+			}
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, &DirNotFoundError{Dir: location}
+		}
+		return nil, &ReadError{Dir: location, Err: err}
+	}
+	return names, nil
+}
+
+// FSSource reads profiles from the subdirectories of Root in an fs.FS,
+// e.g. an embed.FS, os.DirFS, a zip.Reader or an fstest.MapFS.
+type FSSource struct {
+	FS   fs.FS
+	Root string
+}
+
+func NewFSSource(fsys fs.FS, root string) *FSSource {
+	return &FSSource{FS: fsys, Root: root}
+}
+
+func (s *FSSource) Location(profile string) string {
+	return path.Join(s.Root, profile)
+}
+
+func (s *FSSource) List(profile string) ([]string, error) {
+	return listDocuments(s.FS, path.Join(s.Root, profile), s.Location(profile), true)
+}
+
+func (s *FSSource) ListShallow(profile string) ([]string, error) {
+	return listDocuments(s.FS, path.Join(s.Root, profile), s.Location(profile), false)
+}
+
+func (s *FSSource) Read(profile, name string) ([]byte, error) {
+	dirPath := s.Location(profile)
+	content, err := fs.ReadFile(s.FS, path.Join(dirPath, name))
+	if err != nil {
+		return nil, &ReadError{Dir: dirPath, File: name, Err: err}
+	}
+	return content, nil
+}
+
+// MultiSource combines sources, e.g. profiles embedded in the binary with
+// override profiles on disk. Each profile is served by the first source
+// that has it.
+type MultiSource []Source
+
+// find returns the first source that has profile and the names list
+// gives for it.
+func (s MultiSource) find(
+	profile string, list func(Source, string) ([]string, error)) (Source, []string, error) {
+
+	for _, source := range s {
+		names, err := list(source, profile)
+		var dirErr *DirNotFoundError
+		if errors.As(err, &dirErr) {
+			continue
+		}
+		return source, names, err
+	}
+	return nil, nil, &DirNotFoundError{Dir: profile}
+}
+
+func (s MultiSource) Location(profile string) string {
+	if source, _, err := s.find(profile, listShallow); err == nil {
+		return sourceLocation(source, profile)
+	}
+	return profile
+}
+
+func (s MultiSource) List(profile string) ([]string, error) {
+	_, names, err := s.find(profile, Source.List)
+	return names, err
+}
+
+func (s MultiSource) ListShallow(profile string) ([]string, error) {
+	_, names, err := s.find(profile, listShallow)
+	return names, err
+}
+
+func (s MultiSource) Read(profile, name string) ([]byte, error) {
+	source, _, err := s.find(profile, listShallow)
+	if err != nil {
+		return nil, err
+	}
+	return source.Read(profile, name)
+}
+
+// MemorySource keeps profiles in memory. It is safe for concurrent use
+// and notifies watchers on every change.
+type MemorySource struct {
+	mu       sync.Mutex
+	profiles map[string]map[string][]byte
+	watchers map[int]memoryWatcher
+	nextID   int
+}
+
+type memoryWatcher struct {
+	profiles []string
+	onChange func()
+}
+
+func NewMemorySource() *MemorySource {
+	return &MemorySource{
+		profiles: make(map[string]map[string][]byte),
+		watchers: make(map[int]memoryWatcher),
+	}
+}
+
+// Set adds or replaces a document of a profile.
+func (s *MemorySource) Set(profile, name string, content []byte) {
+	s.mu.Lock()
+	if s.profiles[profile] == nil {
+		s.profiles[profile] = make(map[string][]byte)
+	}
+	s.profiles[profile][name] = content
+	s.mu.Unlock()
+	s.notify(profile)
+}
+
+// Delete removes a document of a profile.
+func (s *MemorySource) Delete(profile, name string) {
+	s.mu.Lock()
+	delete(s.profiles[profile], name)
+	s.mu.Unlock()
+	s.notify(profile)
+}
+
+func (s *MemorySource) List(profile string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs, ok := s.profiles[profile]
+	if !ok {
+		return nil, &DirNotFoundError{Dir: profile}
+	}
+	names := make([]string, 0, len(docs))
+	for name := range docs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *MemorySource) Read(profile, name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, ok := s.profiles[profile][name]
+	if !ok {
+		return nil, &ReadError{Dir: profile, File: name, Err: fs.ErrNotExist}
+	}
+	return content, nil
+}
+
+func (s *MemorySource) Watch(ctx context.Context, profiles []string, onChange func()) error {
+	s.mu.Lock()
+	id := s.nextID
+	s.nextID++
+	s.watchers[id] = memoryWatcher{profiles: profiles, onChange: onChange}
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		delete(s.watchers, id)
+		s.mu.Unlock()
+	}()
+	return nil
+}
+
+func (s *MemorySource) notify(profile string) {
+	s.mu.Lock()
+	var callbacks []func()
+	for _, watcher := range s.watchers {
+		for _, watched := range watcher.profiles {
+			if watched == profile {
+				callbacks = append(callbacks, watcher.onChange)
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback()
+	}
+}
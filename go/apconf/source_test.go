@@ -0,0 +1,175 @@
+package apconf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMemorySource(t *testing.T) {
+	source := NewMemorySource()
+	source.Set("base", "crawl.yaml", []byte(`kind: Config
+metadata:
+  name: crawler_config
+spec:
+  num_workers: 30
+`))
+	source.Set("prod", "crawl.yaml", []byte(`kind: Config
+metadata:
+  name: crawler_config
+spec:
+  num_workers: 50
+`))
+
+	cfg, err := LoadConfig("", []string{"base", "prod"}, nil, nil, nil, nil, WithSource(source))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if numWorkers := cfg.config["crawler_config"].(msa)["spec"].(msa)["num_workers"]; numWorkers != 50 {
+		t.Errorf("Expected 'num_workers' to be 50, but got %v", numWorkers)
+	}
+
+	t.Run("watch", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if err := cfg.Watch(ctx, func(err error) { t.Errorf("Reload failed: %v", err) }); err != nil {
+			t.Fatalf("Failed to watch: %v", err)
+		}
+		source.Delete("prod", "crawl.yaml")
+		if numWorkers := cfg.config["crawler_config"].(msa)["spec"].(msa)["num_workers"]; numWorkers != 30 {
+			t.Errorf("Expected 'num_workers' to be 30 after reload, but got %v", numWorkers)
+		}
+	})
+
+	t.Run("concurrent_changes", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if err := cfg.Watch(ctx, func(err error) { t.Errorf("Reload failed: %v", err) }); err != nil {
+			t.Fatalf("Failed to watch: %v", err)
+		}
+		var wg sync.WaitGroup
+		for i := range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				content := fmt.Sprintf("kind: Config\nmetadata:\n  name: crawler_config\nspec:\n  num_workers: %d\n", i)
+				source.Set("base", fmt.Sprintf("w%d.yaml", i), []byte(content))
+				cfg.Documents("Config")
+				cfg.Explain("crawler_config.spec.num_workers")
+				cfg.Layer(ProfileLayer("base"))
+			}()
+		}
+		wg.Wait()
+		if _, ok := cfg.Documents("Config")["crawler_config"]; !ok {
+			t.Errorf("Expected crawler_config after concurrent reloads")
+		}
+	})
+
+	t.Run("missing_profile", func(t *testing.T) {
+		_, err := LoadConfig("", []string{"base", "dev"}, nil, nil, nil, nil, WithSource(source))
+		var dirErr *DirNotFoundError
+		if !errors.As(err, &dirErr) || dirErr.Dir != "dev" {
+			t.Errorf("Expected DirNotFoundError for dev, got %v", err)
+		}
+	})
+
+	t.Run("dir_source_not_watchable", func(t *testing.T) {
+		root := writeProfiles(t, map[string]string{
+			"base/a.yaml": "kind: Config\nmetadata:\n  name: a\nspec: {}\n",
+		})
+		cfg, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if err := cfg.Watch(context.Background(), nil); err == nil {
+			t.Errorf("Expected watching a DirSource to fail")
+		}
+	})
+}
+
+func TestFSSource(t *testing.T) {
+	embedded := fstest.MapFS{
+		"profiles/base/crawl.yaml": &fstest.MapFile{Data: []byte(`kind: Config
+metadata:
+  name: crawler_config
+spec:
+  num_workers: 30
+  queue: default
+`)},
+	}
+
+	t.Run("with_fs", func(t *testing.T) {
+		cfg, err := LoadConfig("profiles", []string{"base"}, nil, nil, nil, nil, WithFS(embedded))
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if numWorkers := cfg.config["crawler_config"].(msa)["spec"].(msa)["num_workers"]; numWorkers != 30 {
+			t.Errorf("Expected 'num_workers' to be 30, but got %v", numWorkers)
+		}
+	})
+
+	t.Run("embedded_base_with_disk_override", func(t *testing.T) {
+		root := writeProfiles(t, map[string]string{
+			"prod/crawl.yaml": "kind: Config\nmetadata:\n  name: crawler_config\nspec:\n  num_workers: 50\n",
+		})
+		source := MultiSource{NewFSSource(embedded, "profiles"), NewDirSource(root)}
+		cfg, err := LoadConfig("", []string{"base", "prod"}, nil, nil, nil, nil, WithSource(source))
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		spec := cfg.config["crawler_config"].(msa)["spec"].(msa)
+		if !reflect.DeepEqual(spec, msa{"num_workers": 50, "queue": "default"}) {
+			t.Errorf("Expected overridden spec, got %v", spec)
+		}
+	})
+
+	t.Run("missing_profile", func(t *testing.T) {
+		_, err := LoadConfig("profiles", []string{"prod"}, nil, nil, nil, nil, WithFS(embedded))
+		var dirErr *DirNotFoundError
+		if !errors.As(err, &dirErr) || dirErr.Dir != "profiles/prod" {
+			t.Errorf("Expected DirNotFoundError for profiles/prod, got %v", err)
+		}
+	})
+}
+
+// unreadableDirFS fails to read the directory dir.
+type unreadableDirFS struct {
+	fstest.MapFS
+	dir string
+}
+
+func (f unreadableDirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name == f.dir {
+		return nil, fs.ErrPermission
+	}
+	return f.MapFS.ReadDir(name)
+}
+
+func TestShallowListing(t *testing.T) {
+	fsys := unreadableDirFS{
+		MapFS: fstest.MapFS{
+			"profiles/base/crawl.yaml":       &fstest.MapFile{Data: []byte("kind: Config\nmetadata:\n  name: a\nspec: {}\n")},
+			"profiles/base/cache/entry.yaml": &fstest.MapFile{Data: []byte("kind: Config\nmetadata:\n  name: b\nspec: {}\n")},
+		},
+		dir: "profiles/base/cache",
+	}
+
+	cfg, err := LoadConfig("profiles", []string{"base"}, nil, nil, nil, nil, WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Expected the unreadable subdirectory to be skipped, got %v", err)
+	}
+	if _, ok := cfg.Documents("Config")["a"]; !ok {
+		t.Errorf("Expected document a to be loaded")
+	}
+
+	_, err = LoadConfig("profiles", []string{"base"}, nil, nil, nil, nil, WithFS(fsys), WithRecursive())
+	var readErr *ReadError
+	if !errors.As(err, &readErr) {
+		t.Errorf("Expected ReadError when loading recursively, got %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package apconf
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Custom tags resolved when decoding YAML scalars:
+//
+//	home: !env HOME              # the value of an environment variable
+//	token: !file /run/secrets/t  # the content of a file
+//	timeout: !duration 30s       # a time.Duration, see time.ParseDuration
+//	maxSize: !bytes 10MB         # an int number of bytes
+//
+// Relative !file paths are resolved like $include paths, against the
+// directory of the profile, and read through its Source, e.g. from an
+// embed.FS. Absolute ones are read from the file system. !bytes accepts
+// the units B, KB, MB, GB and TB, powers of 1000, and KiB, MiB, GiB and
+// TiB, powers of 1024.
+const (
+	envTag      = "!env"
+	fileTag     = "!file"
+	durationTag = "!duration"
+	bytesTag    = "!bytes"
+)
+
+// byteUnit returns the number of bytes in the unit of a byte size, given
+// in upper case.
+func byteUnit(unit string) (float64, bool) {
+	switch unit {
+	case "", "B":
+		return 1, true
+	case "KB":
+		return 1e3, true
+	case "MB":
+		return 1e6, true
+	case "GB":
+		return 1e9, true
+	case "TB":
+		return 1e12, true
+	case "KIB":
+		return 1 << 10, true
+	case "MIB":
+		return 1 << 20, true
+	case "GIB":
+		return 1 << 30, true
+	case "TIB":
+		return 1 << 40, true
+	}
+	return 0, false
+}
+
+// tagResolver replaces the custom tagged scalars of a YAML document by
+// the plain scalars they resolve to. Durations decode as nanoseconds, so
+// their paths are recorded for applyDurations.
+type tagResolver struct {
+	readFile      fileReader
+	durationNodes map[*yaml.Node]bool
+	// durations holds the paths of the durations as keys and indices.
+	durations [][]string
+}
+
+func newTagResolver(readFile fileReader) *tagResolver {
+	return &tagResolver{
+		readFile:      readFile,
+		durationNodes: make(map[*yaml.Node]bool),
+	}
+}
+
+// tagFileReader returns the fileReader of the !file tags in the files of
+// profile.
+func (c *Config) tagFileReader(profile string) fileReader {
+	return func(filePath string) ([]byte, error) {
+		if filepath.IsAbs(filePath) {
+			return os.ReadFile(filePath)
+		}
+		fileProfile, fileName, err := resolveInclude(profile, filePath)
+		if err != nil {
+			return nil, err
+		}
+		return c.source.Read(fileProfile, fileName)
+	}
+}
+
+// resolve resolves the tags nested in node, which is located at prefix.
+func (r *tagResolver) resolve(node *yaml.Node, prefix []string) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if err := r.resolve(child, prefix); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		// Merged keys are located at prefix and overridden by the keys of
+		// the mapping, so they are resolved first
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Tag == "!!merge" {
+				if err := r.resolve(node.Content[i+1], prefix); err != nil {
+					return err
+				}
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Tag == "!!merge" {
+				continue
+			}
+			valuePath := append(slices.Clone(prefix), key.Value)
+			r.durations = slices.DeleteFunc(r.durations, func(duration []string) bool {
+				return len(duration) >= len(valuePath) && slices.Equal(duration[:len(valuePath)], valuePath)
+			})
+			if err := r.resolve(value, valuePath); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if err := r.resolve(item, append(slices.Clone(prefix), strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+	case yaml.AliasNode:
+		return r.resolve(node.Alias, prefix)
+	case yaml.ScalarNode:
+		return r.resolveScalar(node, prefix)
+	}
+	return nil
+}
+
+func (r *tagResolver) resolveScalar(node *yaml.Node, valuePath []string) error {
+	if r.durationNodes[node] {
+		// Resolved before, and reached again through an alias
+		r.durations = append(r.durations, valuePath)
+		return nil
+	}
+	var err error
+	switch node.Tag {
+	case envTag:
+		value, ok := os.LookupEnv(node.Value)
+		if !ok {
+			err = fmt.Errorf("environment variable %s is not set", node.Value)
+		}
+		setScalar(node, "!!str", value)
+	case fileTag:
+		var content []byte
+		content, err = r.readFile(node.Value)
+		setScalar(node, "!!str", string(content))
+	case durationTag:
+		var d time.Duration
+		d, err = time.ParseDuration(node.Value)
+		setScalar(node, "!!int", strconv.FormatInt(int64(d), 10))
+		r.durationNodes[node] = true
+		r.durations = append(r.durations, valuePath)
+	case bytesTag:
+		var n int
+		n, err = parseBytes(node.Value)
+		setScalar(node, "!!int", strconv.Itoa(n))
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("line %d: %s: %w", node.Line, strings.Join(valuePath, "."), err)
+	}
+	return nil
+}
+
+func setScalar(node *yaml.Node, tag, value string) {
+	node.Tag = tag
+	node.Value = value
+	node.Style = 0
+}
+
+// parseBytes parses a number of bytes with an optional unit, e.g. "10MB"
+// or "1.5 GiB".
+func parseBytes(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if end < 0 {
+		end = len(s)
+	}
+	number, err := strconv.ParseFloat(s[:end], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	unit, ok := byteUnit(strings.ToUpper(strings.TrimSpace(s[end:])))
+	if !ok {
+		return 0, fmt.Errorf("invalid byte size %q: unknown unit", s)
+	}
+	bytes := number * unit
+	if bytes > math.MaxInt {
+		return 0, fmt.Errorf("byte size %q is too large", s)
+	}
+	return int(bytes), nil
+}
+
+// applyDurations converts the nanoseconds at the recorded paths of doc,
+// decoded from the resolved document, to time.Duration.
+func (r *tagResolver) applyDurations(doc any) {
+	for _, valuePath := range r.durations {
+		var parent any
+		var key string
+		value := doc
+		for _, segment := range valuePath {
+			parent, key = value, segment
+			switch v := value.(type) {
+			case map[string]any:
+				value = v[segment]
+			case []any:
+				if index, err := strconv.Atoi(segment); err == nil && index < len(v) {
+					value = v[index]
+				}
+			}
+		}
+		nanos, ok := value.(int)
+		if !ok {
+			continue
+		}
+		switch p := parent.(type) {
+		case map[string]any:
+			p[key] = time.Duration(nanos)
+		case []any:
+			index, _ := strconv.Atoi(key)
+			p[index] = time.Duration(nanos)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package apconf
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestYamlTags(t *testing.T) {
+	t.Setenv("APCONF_TEST_HOME", "/home/apconf")
+	secret := filepath.Join(t.TempDir(), "secret")
+	writeFile(t, secret, "s3cr3t")
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  home: !env APCONF_TEST_HOME
+  token: !file ` + secret + `
+  defaults: &defaults
+    timeout: !duration 1m30s
+    retry: !duration 500ms
+  http:
+    <<: *defaults
+    retry: 3
+  timeouts: [!duration 1s, *defaults]
+  "x.y": !duration 2s
+  sizes: [!bytes 10MB, !bytes 1.5KiB, !bytes 512]
+`,
+	})
+
+	cfg, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	defaults := msa{"timeout": 90 * time.Second, "retry": 500 * time.Millisecond}
+	expected := msa{
+		"home":     "/home/apconf",
+		"token":    "s3cr3t",
+		"defaults": defaults,
+		"http":     msa{"timeout": 90 * time.Second, "retry": 3},
+		"timeouts": []any{time.Second, defaults},
+		"x.y":      2 * time.Second,
+		"sizes":    []any{10000000, 1536, 512},
+	}
+	if spec := cfg.config["crawler_config"].(msa)["spec"]; !reflect.DeepEqual(spec, expected) {
+		t.Errorf("Expected spec %v, got %v", expected, spec)
+	}
+}
+
+func TestRelativeFileTag(t *testing.T) {
+	fsys := fstest.MapFS{
+		"profiles/base/crawl.yaml": &fstest.MapFile{Data: []byte(`kind: Config
+metadata:
+  name: crawler_config
+spec:
+  token: !file secrets/token
+  shared: !file ../shared/token
+`)},
+		"profiles/base/secrets/token": &fstest.MapFile{Data: []byte("base-token")},
+		"profiles/shared/token":       &fstest.MapFile{Data: []byte("shared-token")},
+	}
+	cfg, err := LoadConfig("profiles", []string{"base"}, nil, nil, nil, nil, WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	expected := msa{"token": "base-token", "shared": "shared-token"}
+	if spec := cfg.config["crawler_config"].(msa)["spec"]; !reflect.DeepEqual(spec, expected) {
+		t.Errorf("Expected spec %v, got %v", expected, spec)
+	}
+}
+
+func TestYamlTagErrors(t *testing.T) {
+	cases := map[string]string{
+		"!env APCONF_TEST_UNSET": "APCONF_TEST_UNSET is not set",
+		"!file /no/such/file":    "no such file",
+		"!file missing":          "no such file",
+		"!file ../../etc/passwd": "profile directory",
+		"!duration soon":         "invalid duration",
+		"!bytes 10XB":            "unknown unit",
+	}
+	for value, message := range cases {
+		root := writeProfiles(t, map[string]string{
+			"base/crawl.yaml": "kind: Config\nmetadata:\n  name: crawler_config\nspec:\n  value: " + value + "\n",
+		})
+		_, err := LoadConfig(root, []string{"base"}, nil, nil, nil, nil)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%s: expected a ParseError, got %v", value, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), "line 5") || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: unexpected error %v", value, err)
+		}
+	}
+}
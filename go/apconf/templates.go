@@ -0,0 +1,321 @@
+package apconf
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// TemplateMode selects the syntax of the templates in config files.
+type TemplateMode int
+
+const (
+	// GoTemplates renders config files as Go templates, e.g.
+	// "{{ .db.host }}" or "{{ if .debug }}", with the template params as
+	// data. Nested maps are reached with dotted fields.
+	GoTemplates TemplateMode = iota
+	// SnakeCaseTemplates rewrites every bare "{{ var_name }}" to
+	// "{{ .VarName }}" before rendering as Go templates, so the template
+	// params must have PascalCase keys. This keeps config files that use
+	// the same syntax as the Python renderer working.
+	SnakeCaseTemplates
+	// JinjaTemplates renders config files with the subset of Jinja
+	// described in jinja.go, so that a profile tree renders the same as
+	// with the Python apconf. Template functions are not available.
+	JinjaTemplates
+)
+
+// WithTemplateMode sets the syntax of the templates in config files,
+// GoTemplates by default.
+func WithTemplateMode(mode TemplateMode) Option {
+	return func(c *Config) {
+		c.templateMode = mode
+	}
+}
+
+// render renders the content of a config file with the template params.
+// It also returns the line of content each rendered line comes from.
+func (c *Config) render(content []byte) ([]byte, []int, error) {
+	if c.templateMode == JinjaTemplates {
+		return renderJinja(content, c.templateParams, c.strictTemplates)
+	}
+	funcs := c.templateFuncMap()
+	if c.templateMode == SnakeCaseTemplates {
+		rendered, lines, err := renderTemplate(
+			preprocessTemplateForGo(content, funcs), c.templateParams, funcs, c.strictTemplates)
+		var undefinedErr *UndefinedParamError
+		if errors.As(err, &undefinedErr) {
+			undefinedErr.Param = snakeCaseParam(content, undefinedErr.Param)
+		}
+		return rendered, lines, err
+	}
+	return renderTemplate(content, c.templateParams, funcs, c.strictTemplates)
+}
+
+// lineMarker delimits the markers of source lines inserted in the text of
+// templates. NUL cannot appear in YAML, JSON or TOML files.
+const lineMarker = 0
+
+// markLines returns text, which starts at line of its template, with a
+// marker of the source line before it and after each of its newlines.
+func markLines(text []byte, line int) []byte {
+	marked := make([]byte, 0, len(text)+8)
+	marked = appendLineMarker(marked, line)
+	for _, b := range text {
+		marked = append(marked, b)
+		if b == '\n' {
+			line++
+			marked = appendLineMarker(marked, line)
+		}
+	}
+	return marked
+}
+
+func appendLineMarker(b []byte, line int) []byte {
+	b = append(b, lineMarker)
+	b = strconv.AppendInt(b, int64(line), 10)
+	return append(b, lineMarker)
+}
+
+// stripLineMarkers removes the markers of markLines from rendered and
+// returns, for each line of the result, the source line it comes from:
+// that of its first marker, or for lines output by template actions,
+// e.g. toYaml | nindent, that of the line before.
+func stripLineMarkers(rendered []byte) ([]byte, []int) {
+	stripped := make([]byte, 0, len(rendered))
+	lines := []int{0}
+	for i := 0; i < len(rendered); i++ {
+		b := rendered[i]
+		if b == lineMarker {
+			end := bytes.IndexByte(rendered[i+1:], lineMarker)
+			line, _ := strconv.Atoi(string(rendered[i+1 : i+1+end]))
+			if lines[len(lines)-1] == 0 {
+				lines[len(lines)-1] = line
+			}
+			i += end + 1
+			continue
+		}
+		stripped = append(stripped, b)
+		if b == '\n' {
+			lines = append(lines, 0)
+		}
+	}
+	for i, line := range lines {
+		if line != 0 {
+			continue
+		}
+		if i == 0 {
+			lines[i] = 1
+		} else {
+			lines[i] = lines[i-1]
+		}
+	}
+	return stripped, lines
+}
+
+// sourceLine returns the source line of the rendered line, see render.
+func sourceLine(lines []int, line int) int {
+	if line < 1 || line > len(lines) {
+		return line
+	}
+	return lines[line-1]
+}
+
+// snakeCaseParam returns the name written in content that the
+// SnakeCaseTemplates mode rewrote to param, e.g. "proc_id" for "ProcId".
+func snakeCaseParam(content []byte, param string) string {
+	for _, match := range snakeCaseVarRe.FindAllSubmatch(content, -1) {
+		if name := string(match[1]); toPascalCase(name) == param {
+			return name
+		}
+	}
+	return param
+}
+
+// WithStrictTemplates makes rendering fail with a *TemplateError caused
+// by an *UndefinedParamError when a template uses an undefined param,
+// instead of rendering "<no value>", or nothing in the JinjaTemplates mode.
+// Params given to the default and required functions, or to the default
+// filter of Jinja, may be undefined, e.g. {{ .log_dir | default "/var/log" }}.
+func WithStrictTemplates() Option {
+	return func(c *Config) {
+		c.strictTemplates = true
+	}
+}
+
+var missingKeyRe = regexp.MustCompile(
+	`^template: [^:]*:(\d+):\d+: executing "[^"]*" at <\.?([^>]*)>: map has no entry for key`)
+
+// undefinedParam converts the error of executing a Go template with
+// missingkey=error to *UndefinedParamError if a param is missing.
+func undefinedParam(err error) error {
+	match := missingKeyRe.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	line, _ := strconv.Atoi(match[1])
+	return &UndefinedParamError{Line: line, Param: match[2]}
+}
+
+// checkNoValue fails with *UndefinedParamError if rendered holds the
+// "<no value>" printed for nil values. The param is the first one output
+// by tmpl that is nil in params.
+func checkNoValue(
+	tmpl *template.Template, rendered []byte, lines []int, params map[string]any) error {
+
+	index := bytes.Index(rendered, []byte("<no value>"))
+	if index < 0 {
+		return nil
+	}
+	renderedLine := bytes.Count(rendered[:index], []byte("\n")) + 1
+	err := &UndefinedParamError{Line: sourceLine(lines, renderedLine), Param: "<no value>"}
+	walkTemplate(tmpl.Tree.Root, true, func(node parse.Node, rootDot bool) bool {
+		action, ok := node.(*parse.ActionNode)
+		if !ok || !rootDot || len(action.Pipe.Cmds) != 1 || len(action.Pipe.Cmds[0].Args) != 1 {
+			return true
+		}
+		fieldNode, ok := action.Pipe.Cmds[0].Args[0].(*parse.FieldNode)
+		if !ok {
+			return true
+		}
+		if value, found := lookupParam(params, fieldNode.Ident); found && value == nil {
+			err.Line = action.Line
+			err.Param = strings.Join(fieldNode.Ident, ".")
+			return false
+		}
+		return true
+	})
+	return err
+}
+
+// allowMissingDefaults rewrites the missing params given to the default
+// and required functions by tmpl, e.g. ".log_dir" in
+// {{ .log_dir | default "/var/log" }}, to (index . "log_dir"), which
+// evaluates to nil where missingkey=error would fail. Only the last key
+// of a param may be missing.
+func allowMissingDefaults(tmpl *template.Template, params map[string]any) {
+	walkTemplate(tmpl.Tree.Root, true, func(node parse.Node, rootDot bool) bool {
+		pipe, ok := node.(*parse.PipeNode)
+		if !ok || !rootDot {
+			return true
+		}
+		for i, cmd := range pipe.Cmds {
+			if isDefaultCall(cmd) {
+				for j := 1; j < len(cmd.Args); j++ {
+					cmd.Args[j] = indexMissingParam(tmpl, cmd.Args[j], params)
+				}
+			}
+			if i+1 < len(pipe.Cmds) && isDefaultCall(pipe.Cmds[i+1]) && len(cmd.Args) == 1 {
+				cmd.Args[0] = indexMissingParam(tmpl, cmd.Args[0], params)
+			}
+		}
+		return true
+	})
+}
+
+func isDefaultCall(cmd *parse.CommandNode) bool {
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	return ok && (ident.Ident == "default" || ident.Ident == "required")
+}
+
+// indexMissingParam returns the (index . key...) pipeline reading the
+// param of node if it is missing, or node.
+func indexMissingParam(
+	tmpl *template.Template, node parse.Node, params map[string]any) parse.Node {
+
+	field, ok := node.(*parse.FieldNode)
+	if !ok {
+		return node
+	}
+	if _, found := lookupParam(params, field.Ident); found {
+		return node
+	}
+	parent, _ := lookupParam(params, field.Ident[:len(field.Ident)-1])
+	if _, ok := parent.(map[string]any); !ok {
+		return node
+	}
+	args := []parse.Node{
+		parse.NewIdentifier("index").SetTree(tmpl.Tree).SetPos(field.Pos),
+		&parse.DotNode{NodeType: parse.NodeDot, Pos: field.Pos},
+	}
+	for _, key := range field.Ident {
+		args = append(args, &parse.StringNode{
+			NodeType: parse.NodeString, Pos: field.Pos, Quoted: strconv.Quote(key), Text: key,
+		})
+	}
+	cmd := &parse.CommandNode{NodeType: parse.NodeCommand, Pos: field.Pos, Args: args}
+	return &parse.PipeNode{
+		NodeType: parse.NodePipe, Pos: field.Pos, Cmds: []*parse.CommandNode{cmd},
+	}
+}
+
+func lookupParam(params map[string]any, path []string) (any, bool) {
+	var value any = params
+	for _, key := range path {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// walkTemplate calls visit for node and the nodes nested in it, until
+// visit returns false. rootDot tells whether dot is the template data at
+// node, rather than a value set by range or with.
+func walkTemplate(
+	node parse.Node, rootDot bool, visit func(node parse.Node, rootDot bool) bool) bool {
+
+	if node == nil || reflect.ValueOf(node).IsNil() {
+		return true
+	}
+	if !visit(node, rootDot) {
+		return false
+	}
+	walkBranch := func(branch *parse.BranchNode, bodyRootDot bool) bool {
+		return walkTemplate(branch.Pipe, rootDot, visit) &&
+			walkTemplate(branch.List, bodyRootDot, visit) &&
+			walkTemplate(branch.ElseList, rootDot, visit)
+	}
+	switch n := node.(type) {
+	case *parse.ListNode:
+		for _, child := range n.Nodes {
+			if !walkTemplate(child, rootDot, visit) {
+				return false
+			}
+		}
+	case *parse.ActionNode:
+		return walkTemplate(n.Pipe, rootDot, visit)
+	case *parse.IfNode:
+		return walkBranch(&n.BranchNode, rootDot)
+	case *parse.RangeNode:
+		return walkBranch(&n.BranchNode, false)
+	case *parse.WithNode:
+		return walkBranch(&n.BranchNode, false)
+	case *parse.TemplateNode:
+		return walkTemplate(n.Pipe, rootDot, visit)
+	case *parse.PipeNode:
+		for _, cmd := range n.Cmds {
+			if !walkTemplate(cmd, rootDot, visit) {
+				return false
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if !walkTemplate(arg, rootDot, visit) {
+				return false
+			}
+		}
+	case *parse.ChainNode:
+		return walkTemplate(n.Node, rootDot, visit)
+	}
+	return true
+}
@@ -0,0 +1,189 @@
+package apconf
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTemplateModes(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"native/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  host: {{ .db.host }}
+  port: {{ .db.port | default 5432 }}
+  {{- if .debug }}
+  level: debug
+  {{- end }}
+  workers: [{{ range $i, $w := .workers }}{{ if $i }}, {{ end }}{{ $w }}{{ end }}]
+`,
+		"snake/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  host: {{ db_host }}
+  name: {{ upper "crawler" }}
+  home: {{ env "APCONF_TEST_HOME" }}
+`,
+	})
+	t.Setenv("APCONF_TEST_HOME", "/home/apconf")
+
+	cfg, err := LoadConfig(root, []string{"native"}, map[string]any{
+		"db":      map[string]any{"host": "localhost"},
+		"debug":   true,
+		"workers": []any{"a", "b"},
+	}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	expected := map[string]any{
+		"host":    "localhost",
+		"port":    5432,
+		"level":   "debug",
+		"workers": []any{"a", "b"},
+	}
+	if spec := cfg.config["crawler_config"].(map[string]any)["spec"]; !reflect.DeepEqual(spec, expected) {
+		t.Errorf("Expected spec %v, got %v", expected, spec)
+	}
+
+	if _, err := LoadConfig(root, []string{"snake"}, map[string]any{"DbHost": "localhost"}, nil, nil, nil); err == nil {
+		t.Errorf("Expected the snake_case syntax to fail in the Go template mode")
+	}
+	cfg, err = LoadConfig(root, []string{"snake"}, map[string]any{"DbHost": "localhost"}, nil, nil, nil,
+		WithTemplateMode(SnakeCaseTemplates))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	expected = map[string]any{"host": "localhost", "name": "CRAWLER", "home": "/home/apconf"}
+	if spec := cfg.config["crawler_config"].(map[string]any)["spec"]; !reflect.DeepEqual(spec, expected) {
+		t.Errorf("Expected spec %v, got %v", expected, spec)
+	}
+}
+
+func TestStrictTemplates(t *testing.T) {
+	cases := []struct {
+		mode     TemplateMode
+		template string
+		line     int
+		param    string
+	}{
+		{GoTemplates, "{{ .proc_idd }}", 5, "proc_idd"},
+		{GoTemplates, "{{ .db.hostt }}", 5, "db.hostt"},
+		{GoTemplates, "{{ .nothing }}", 5, "nothing"},
+		{SnakeCaseTemplates, "{{ proc_idd }}", 5, "proc_idd"},
+		{JinjaTemplates, "{{ proc_idd }}", 5, "proc_idd"},
+		{JinjaTemplates, "{{ db.hostt | upper }}", 5, "db.hostt"},
+		{JinjaTemplates, "{% if missing %}x{% endif %}", 5, "missing"},
+	}
+	params := map[string]any{"db": map[string]any{"host": "localhost"}, "nothing": nil}
+	for _, tc := range cases {
+		root := writeProfiles(t, map[string]string{
+			"base/crawl.yaml": "kind: Config\nmetadata:\n  name: crawler_config\nspec:\n  path: " + tc.template + "\n",
+		})
+		if _, err := LoadConfig(root, []string{"base"}, params, nil, nil, nil, WithTemplateMode(tc.mode)); err != nil {
+			t.Errorf("%s: expected the lenient mode to succeed, got %v", tc.template, err)
+		}
+		_, err := LoadConfig(root, []string{"base"}, params, nil, nil, nil,
+			WithTemplateMode(tc.mode), WithStrictTemplates())
+		var tmplErr *TemplateError
+		var undefinedErr *UndefinedParamError
+		if !errors.As(err, &tmplErr) || !errors.As(err, &undefinedErr) {
+			t.Errorf("%s: expected an UndefinedParamError, got %v", tc.template, err)
+			continue
+		}
+		if tmplErr.File != "crawl.yaml" || undefinedErr.Line != tc.line || undefinedErr.Param != tc.param {
+			t.Errorf("%s: expected line %d and param %s, got %v", tc.template, tc.line, tc.param, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		mode     TemplateMode
+		template string
+	}{
+		{JinjaTemplates, "{{ db.port | default(5432) }}"},
+		{GoTemplates, `{{ .db.port | default 5432 }}`},
+		{GoTemplates, `{{ default 5432 .port }}`},
+		{GoTemplates, `{{ if true }}{{ .port | default 5432 | printf "%v" }}{{ end }}`},
+		{SnakeCaseTemplates, `{{ .Port | default 5432 }}`},
+	} {
+		root := writeProfiles(t, map[string]string{
+			"base/crawl.yaml": "kind: Config\nmetadata:\n  name: a\nspec:\n  port: " + tc.template + "\n",
+		})
+		cfg, err := LoadConfig(root, []string{"base"}, params, nil, nil, nil,
+			WithTemplateMode(tc.mode), WithStrictTemplates())
+		if err != nil {
+			t.Errorf("%s: expected default to accept undefined params, got %v", tc.template, err)
+			continue
+		}
+		if port := cfg.config["a"].(map[string]any)["spec"].(map[string]any)["port"]; port != 5432 {
+			t.Errorf("%s: expected port 5432, got %v", tc.template, port)
+		}
+	}
+
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yaml": "kind: Config\nmetadata:\n  name: a\nspec:\n  port: " +
+			`{{ .port | required "port is required" }}` + "\n",
+	})
+	_, err := LoadConfig(root, []string{"base"}, params, nil, nil, nil, WithStrictTemplates())
+	if err == nil || !strings.Contains(err.Error(), "port is required") {
+		t.Errorf("Expected the message of required, got %v", err)
+	}
+}
+
+func TestReferencedParams(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"go/crawl.yaml": `spec:
+  host: {{ .db.host }}
+  name: {{ .name | upper }}
+  {{- range .hosts }}
+  - {{ .address }} {{ $.region }}
+  {{- end }}
+  {{ if .debug }}debug{{ end }}
+`,
+		"jinja/crawl.yaml": `spec:
+  host: {{ db.host }}:{{ db['port'] | default(5432) }}
+  {%- set suffix = env ~ '-' ~ name %}
+  {%- for h in hosts %}
+  - {{ h.address }}-{{ loop.index }}-{{ suffix }}
+  {%- endfor %}
+`,
+		"when/crawl.yaml": `kind: Config
+metadata:
+  name: worker
+  when: role == "w" && (limits.cpu > 2 || !debug)
+spec:
+  encoder: {$include: ../shared/encoder.yaml}
+`,
+		"shared/encoder.yaml": "timeKey: {{ .time_key }}\nlevel: {$include: level.yaml}\n",
+		"shared/level.yaml":   "{{ .level }}\n",
+	})
+	params, err := ReferencedParams(root, []string{"go"})
+	if err != nil {
+		t.Fatalf("Failed to list params: %v", err)
+	}
+	expected := []string{"db.host", "debug", "hosts", "name", "region"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("Expected params %v, got %v", expected, params)
+	}
+
+	params, err = ReferencedParams(root, []string{"jinja"}, WithTemplateMode(JinjaTemplates))
+	if err != nil {
+		t.Fatalf("Failed to list params: %v", err)
+	}
+	expected = []string{"db.host", "db.port", "env", "hosts", "name"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("Expected params %v, got %v", expected, params)
+	}
+
+	params, err = ReferencedParams(root, []string{"when"})
+	if err != nil {
+		t.Fatalf("Failed to list params: %v", err)
+	}
+	expected = []string{"debug", "level", "limits.cpu", "role", "time_key"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("Expected params %v, got %v", expected, params)
+	}
+}
@@ -172,25 +172,25 @@ func ToInt(value any) (int, error) {
 
 // deepClone performs a deep copy of a map[string]any
 func deepClone(src map[string]any) map[string]any {
-	clone := make(map[string]any)
+	clone := make(map[string]any, len(src))
 	for k, v := range src {
-		switch v := v.(type) {
-		case map[string]any:
-			clone[k] = deepClone(v) // Recursively clone nested maps
-		case []any:
-			cloneArr := make([]any, len(v))
-			for i, item := range v {
-				switch item := item.(type) {
-				case map[string]any:
-					cloneArr[i] = deepClone(item)
-				default:
-					cloneArr[i] = item
-				}
-			}
-			clone[k] = cloneArr
-		default:
-			clone[k] = v
-		}
+		clone[k] = cloneValue(v)
 	}
 	return clone
 }
+
+// cloneValue deep-copies maps and lists, other values are returned as is
+func cloneValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		return deepClone(v) // Recursively clone nested maps
+	case []any:
+		cloneArr := make([]any, len(v))
+		for i, item := range v {
+			cloneArr[i] = cloneValue(item)
+		}
+		return cloneArr
+	default:
+		return v
+	}
+}
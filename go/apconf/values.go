@@ -0,0 +1,94 @@
+package apconf
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValuesName is the name of the optional values file of a profile. Like
+// a Helm values.yaml, it holds template params, e.g.
+//
+//	num_workers: 4
+//	db:
+//	  host: localhost
+//
+// Values files are not rendered and not loaded as documents. They are
+// merged as plain data: keys such as $delete or $patch are kept as
+// params rather than read as merge directives.
+const ValuesName = "values.yaml"
+
+// WithValuesFiles deep-merges the template params in the given YAML files
+// over those of the profiles' values files, in order. The template params
+// passed to LoadConfig take precedence over all values files. They are
+// merged as plain data, like the values files of profiles, see ValuesName.
+func WithValuesFiles(paths ...string) Option {
+	return func(c *Config) {
+		c.valuesFiles = append(c.valuesFiles, paths...)
+	}
+}
+
+// isProfileFile tells whether the file name of a profile describes the
+// profile rather than holding documents.
+func isProfileFile(name string) bool {
+	return name == ManifestName || name == ValuesName
+}
+
+// loadValuesFiles deep-merges over params the values files of profiles,
+// in order, and then those given WithValuesFiles.
+func (c *Config) loadValuesFiles(params map[string]any, profiles []string) (map[string]any, error) {
+	for _, profile := range profiles {
+		names, err := listShallow(c.source, profile)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(names, ValuesName) {
+			continue
+		}
+		content, err := c.source.Read(profile, ValuesName)
+		if err != nil {
+			return nil, err
+		}
+		if params, err = mergeValuesContent(params, content); err != nil {
+			return nil, &ParseError{Dir: sourceLocation(c.source, profile), File: ValuesName, Err: err}
+		}
+	}
+	for _, valuesPath := range c.valuesFiles {
+		content, err := os.ReadFile(valuesPath)
+		if err != nil {
+			return nil, &ReadError{Dir: filepath.Dir(valuesPath), File: filepath.Base(valuesPath), Err: err}
+		}
+		if params, err = mergeValuesContent(params, content); err != nil {
+			return nil, &ParseError{Dir: filepath.Dir(valuesPath), File: filepath.Base(valuesPath), Err: err}
+		}
+	}
+	return params, nil
+}
+
+func mergeValuesContent(params map[string]any, content []byte) (map[string]any, error) {
+	var values map[string]any
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return nil, err
+	}
+	return mergeParams(params, values), nil
+}
+
+// mergeParams deep-merges the template params overlay onto base and
+// returns the result. Unlike mergeMaps, it does not interpret merge
+// directives such as $delete or $patch: params are plain data, so maps
+// are merged recursively and any other value replaces the base value.
+func mergeParams(base, overlay map[string]any) map[string]any {
+	merged := deepClone(base)
+	for key, value := range overlay {
+		baseMap, baseIsMap := merged[key].(map[string]any)
+		overlayMap, overlayIsMap := value.(map[string]any)
+		if baseIsMap && overlayIsMap {
+			merged[key] = mergeParams(baseMap, overlayMap)
+		} else {
+			merged[key] = cloneValue(value)
+		}
+	}
+	return merged
+}
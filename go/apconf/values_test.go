@@ -0,0 +1,67 @@
+package apconf
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValuesFiles(t *testing.T) {
+	root := writeProfiles(t, map[string]string{
+		"base/crawl.yaml": `kind: Config
+metadata:
+  name: crawler_config
+spec:
+  num_workers: {{ .num_workers }}
+  db: {{ .db.host }}:{{ .db.port }}
+  region: {{ .region }}
+`,
+		"base/values.yaml": `num_workers: 2
+db:
+  host: localhost
+  port: 5432
+region: eu
+`,
+		"prod/values.yaml": `num_workers: 8
+db:
+  host: db.prod
+`,
+	})
+	extra := filepath.Join(t.TempDir(), "deploy.yaml")
+	writeFile(t, extra, "db:\n  port: 6432\nregion: us\n")
+
+	cfg, err := LoadConfig(root, []string{"base", "prod"}, map[string]any{"region": "ap"}, nil, nil, nil,
+		WithValuesFiles(extra))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	expected := map[string]any{"num_workers": 8, "db": "db.prod:6432", "region": "ap"}
+	if spec := cfg.config["crawler_config"].(map[string]any)["spec"]; !reflect.DeepEqual(spec, expected) {
+		t.Errorf("Expected spec %v, got %v", expected, spec)
+	}
+
+	// Keys that look like merge directives are plain params
+	writeFile(t, extra, "db:\n  $delete: true\n")
+	cfg, err = LoadConfig(root, []string{"base"}, nil, nil, nil, nil, WithValuesFiles(extra))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if db := cfg.config["crawler_config"].(map[string]any)["spec"].(map[string]any)["db"]; db != "localhost:5432" {
+		t.Errorf("Expected db params to be kept, got %v", db)
+	}
+
+	_, err = LoadConfig(root, []string{"base"}, nil, nil, nil, nil,
+		WithValuesFiles(filepath.Join(t.TempDir(), "missing.yaml")))
+	var readErr *ReadError
+	if !errors.As(err, &readErr) {
+		t.Errorf("Expected a ReadError, got %v", err)
+	}
+
+	writeFile(t, extra, "db: [\n")
+	_, err = LoadConfig(root, []string{"base"}, nil, nil, nil, nil, WithValuesFiles(extra))
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.File != "deploy.yaml" {
+		t.Errorf("Expected a ParseError for deploy.yaml, got %v", err)
+	}
+}
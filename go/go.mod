@@ -3,6 +3,7 @@ module github.com/samoand/apconf/go
 go 1.23
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	go.uber.org/zap v1.27.0
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 	gopkg.in/yaml.v3 v3.0.1